@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"github.com/fsouza/go-dockerclient"
+	"gopkg.in/alecthomas/kingpin.v1"
+	"os"
+	"strconv"
+)
+
+// subcommand to open a shell in a kept container
+const command_attach = "attach"
+
+// parse the arguments of the attach subcommand
+func parse_attach_arguments(basename string, cli_args []string) Arguments {
+
+	var args Arguments
+
+	parser := kingpin.New(fmt.Sprintf("%s %s", basename, command_attach), "Open a shell in a kept build container.")
+
+	parse_common_flags(parser, &args)
+	args.attach_job_name = parser.Arg("job_name", "Job name of the kept build.").Required().String()
+	args.attach_build_id = parser.Arg("build_id", "Build id of the kept build.").Required().Int()
+
+	parser.Version(version)
+	parser.Parse(cli_args)
+
+	if *args.debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	return args
+}
+
+// select the newest of the containers found
+func newest_container(containers []docker.APIContainers) (docker.APIContainers, error) {
+	if len(containers) == 0 {
+		return docker.APIContainers{}, fmt.Errorf("No kept container found for job '%s' build %d", config.job_name, config.build_id)
+	}
+	var newest *docker.APIContainers
+	for i := range containers {
+		// sidecar services are never kept
+		if _, ok := containers[i].Labels[label_service]; ok {
+			continue
+		}
+		if newest == nil || containers[i].Created > newest.Created {
+			newest = &containers[i]
+		}
+	}
+	if newest == nil {
+		return docker.APIContainers{}, fmt.Errorf("No kept container found for job '%s' build %d", config.job_name, config.build_id)
+	}
+	return *newest, nil
+}
+
+// ensure the container belongs to the authenticated caller
+func check_container_owner(labels map[string]string, uid int) error {
+	if labels[label_uid] != strconv.Itoa(uid) {
+		return fmt.Errorf("Container is owned by uid %s, not by uid %d", labels[label_uid], uid)
+	}
+	return nil
+}
+
+// shell the container was created with, containers without the label use the default
+func container_shell(labels map[string]string) string {
+	if shell := labels[label_shell]; shell != "" {
+		return shell
+	}
+	return config.default_shell
+}
+
+// open an interactive shell in a kept container
+func attach() (int, error) {
+	config.job_name = *args.attach_job_name
+	config.build_id = *args.attach_build_id
+
+	dw, err := docker_wrapper.New()
+	if err != nil {
+		return -1, err
+	}
+
+	containers, err := dw.ListContainersByLabel(
+		label_managed,
+		fmt.Sprintf("%s=%s", label_job_name, config.job_name),
+		fmt.Sprintf("%s=%d", label_build_id, config.build_id),
+	)
+	if err != nil {
+		return -1, err
+	}
+	container, err := newest_container(containers)
+	if err != nil {
+		return -1, err
+	}
+
+	err = check_container_owner(container.Labels, config.caller.uid)
+	audit_policy("attach_owner", container.ID, err)
+	if err != nil {
+		return -1, err
+	}
+
+	err = dw.Use(container.ID)
+	if err != nil {
+		return -1, err
+	}
+	audit_record_container(dw)
+
+	// the base images of images built from a Dockerfile have been checked by the build
+	if !is_build_image(dw.ImageName) {
+		err = check_image_allowed(dw.ImageName)
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	started, err := dw.Start()
+	if err != nil {
+		return -1, err
+	}
+	if started {
+		log.Infof("Restarted kept container %s", container.ID)
+	}
+
+	// pass the terminal through, piped commands are read from stdin without one
+	dw.Stdin = os.Stdin
+	tty := is_terminal(os.Stdin.Fd())
+	if tty {
+		restore, err := terminal_make_raw(os.Stdin.Fd())
+		if err != nil {
+			return -1, err
+		}
+		defer restore()
+	}
+
+	// the exec runs as the jenkins user of the container in the shell of the build
+	command := []string{container_shell(container.Labels)}
+	ret_val, err := dw.RunCommandAttach(command, tty)
+
+	// leave the container as it was found
+	if started {
+		stop_err := dw.Stop()
+		if stop_err != nil {
+			log.Warn(stop_err)
+		}
+	}
+
+	return ret_val, err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestArgumentsAttach(t *testing.T) {
+	parse_arguments([]string{"/usr/bin/jenkins_docker_wrapper", "attach", "kunde1", "42"})
+	assert.Equal(t, command_attach, config.command, "Attach subcommand not detected")
+	assert.Equal(t, "kunde1", *args.attach_job_name, "Job name not correct")
+	assert.Equal(t, 42, *args.attach_build_id, "Build id not correct")
+	assert.Equal(t, []string{}, config.container_args, "Container arguments not empty")
+
+	parse_arguments([]string{"jenkins_docker_run", "attach", "myscript"})
+	assert.Equal(t, "", config.command, "Legacy mode must not parse subcommands")
+	assert.Equal(t, "attach", *args.image_name, "Image name is not correct")
+}
+
+func TestNewestContainer(t *testing.T) {
+	_, err := newest_container([]docker.APIContainers{})
+	assert.NotEqual(t, nil, err, "Missing container not detected")
+
+	container, err := newest_container([]docker.APIContainers{
+		{ID: "old", Created: 100},
+		{ID: "new", Created: 200},
+	})
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "new", container.ID, "Newest container not selected")
+
+	container, err = newest_container([]docker.APIContainers{
+		{ID: "build", Created: 100},
+		{ID: "service", Created: 200, Labels: map[string]string{label_service: "db"}},
+	})
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "build", container.ID, "Service container selected")
+}
+
+func TestCheckContainerOwner(t *testing.T) {
+	labels := map[string]string{label_uid: "1000"}
+	assert.Equal(t, nil, check_container_owner(labels, 1000), "Owner not accepted")
+	assert.NotEqual(t, nil, check_container_owner(labels, 1001), "Other uid accepted")
+	assert.NotEqual(t, nil, check_container_owner(map[string]string{}, 1000), "Container without owner accepted")
+}
+
+func TestAttachBuildImage(t *testing.T) {
+	image := build_image_name("kunde1", "0123456789abcdef0123456789abcdef")
+	assert.Equal(t, true, is_build_image(image), "Image built from a Dockerfile not accepted")
+	assert.Equal(t, false, is_build_image("evil/image:latest"), "Other image accepted")
+	assert.Equal(t, false, is_build_image(build_image_repository+"/kunde1:latest"), "Image without content hash accepted")
+	assert.Equal(t, false, is_build_image(build_image_repository+"/kunde1/x:0123456789abcdef"), "Nested repository accepted")
+}
+
+func TestContainerShell(t *testing.T) {
+	config.default_shell = "/bin/bash"
+	assert.Equal(t, "/bin/zsh", container_shell(map[string]string{label_shell: "/bin/zsh"}), "Shell of the build not used")
+	assert.Equal(t, "/bin/bash", container_shell(map[string]string{}), "Default shell not used without label")
+}
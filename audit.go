@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// default location of the audit log
+const default_audit_log = "/var/log/jenkins_docker_wrapper.audit.log"
+
+// exit code of a go panic
+const exit_code_panic = 2
+
+// a single decision of a policy check
+type policy_decision struct {
+	Check   string `json:"check"`
+	Subject string `json:"subject"`
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// container started by an invocation
+type audit_container struct {
+	ContainerID  string   `json:"container_id"`
+	ImageName    string   `json:"image_name"`
+	ImageID      string   `json:"image_id"`
+	ImageDigests []string `json:"image_digests"`
+}
+
+// audit record of a single invocation
+type audit_record struct {
+	RealUID         int                `json:"real_uid"`
+	RealGID         int                `json:"real_gid"`
+	EffectiveUID    int                `json:"effective_uid"`
+	User            string             `json:"user"`
+	EnvUser         string             `json:"env_user"`
+	Arguments       []string           `json:"arguments"`
+	JobName         string             `json:"job_name"`
+	BuildID         int                `json:"build_id"`
+	Images          []string           `json:"images"`
+	Containers      []audit_container  `json:"containers"`
+	Mounts          []string           `json:"mounts"`
+	EnvKeys         []string           `json:"env_keys"`
+	PolicyDecisions []policy_decision  `json:"policy_decisions"`
+	ExitCode        int                `json:"exit_code"`
+	Error           string             `json:"error,omitempty"`
+	StartedAt       time.Time          `json:"started_at"`
+	DurationSeconds float64            `json:"duration_seconds"`
+	Durations       map[string]float64 `json:"durations"`
+}
+
+var audit audit_record
+
+var audit_mutex sync.Mutex
+
+var audit_once sync.Once
+
+// remembers the last fatal error for the audit record
+type audit_error_hook struct{}
+
+func (h *audit_error_hook) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel}
+}
+
+func (h *audit_error_hook) Fire(entry *log.Entry) error {
+	audit_mutex.Lock()
+	defer audit_mutex.Unlock()
+	audit.Error = entry.Message
+	return nil
+}
+
+// forwards audit records to syslog
+type audit_syslog_hook struct {
+	writer *syslog.Writer
+}
+
+func (h *audit_syslog_hook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *audit_syslog_hook) Fire(entry *log.Entry) error {
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+	return h.writer.Info(strings.TrimSpace(line))
+}
+
+// start the audit record of this invocation
+func audit_start() {
+	audit = audit_record{
+		RealUID:         os.Getuid(),
+		RealGID:         os.Getgid(),
+		EffectiveUID:    os.Geteuid(),
+		EnvUser:         os.Getenv("USER"),
+		Arguments:       os.Args,
+		ExitCode:        -1,
+		StartedAt:       time.Now(),
+		Durations:       map[string]float64{},
+		PolicyDecisions: []policy_decision{},
+		Containers:      []audit_container{},
+	}
+
+	log.AddHook(&audit_error_hook{})
+
+	// log.Fatal exits without running deferred functions
+	log.RegisterExitHandler(func() {
+		audit_write(1)
+	})
+}
+
+// record the decision of a policy check
+func audit_policy(check string, subject string, err error) {
+	audit_mutex.Lock()
+	defer audit_mutex.Unlock()
+	decision := policy_decision{
+		Check:   check,
+		Subject: subject,
+		Allowed: err == nil,
+	}
+	if err != nil {
+		decision.Reason = err.Error()
+	}
+	audit.PolicyDecisions = append(audit.PolicyDecisions, decision)
+}
+
+// record the duration of a phase
+func audit_phase(name string, start time.Time) {
+	audit_mutex.Lock()
+	defer audit_mutex.Unlock()
+	audit.Durations[name] = time.Since(start).Seconds()
+}
+
+// record details of a started container
+func audit_record_container(dw *docker_wrapper.DockerWrapper) {
+	record := audit_container{
+		ContainerID: dw.ContainerID(),
+		ImageName:   dw.ImageName,
+	}
+	image, err := dw.InspectImage()
+	if err != nil {
+		log.Warnf("Can't inspect image '%s': %s", dw.ImageName, err)
+	} else {
+		record.ImageID = image.ID
+		record.ImageDigests = image.RepoDigests
+	}
+
+	audit_mutex.Lock()
+	defer audit_mutex.Unlock()
+	audit.Containers = append(audit.Containers, record)
+}
+
+// keys of an environment, never the values
+func environment_keys(env []string) []string {
+	keys := []string{}
+	for _, elem := range env {
+		keys = append(keys, strings.SplitN(elem, "=", 2)[0])
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// record a panic and continue panicking
+func audit_recover() {
+	if r := recover(); r != nil {
+		audit_mutex.Lock()
+		if audit.Error == "" {
+			audit.Error = fmt.Sprint(r)
+		}
+		audit_mutex.Unlock()
+		audit_write(exit_code_panic)
+		panic(r)
+	}
+}
+
+// open the audit log, it has to be owned by us
+func open_audit_log(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok && int(sys.Uid) != os.Geteuid() {
+		file.Close()
+		return nil, fmt.Errorf("Audit log '%s' is not owned by uid %d", path, os.Geteuid())
+	}
+	return file, nil
+}
+
+// fields of the audit record for the logger
+func (r *audit_record) fields() (log.Fields, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	fields := log.Fields{}
+	err = json.Unmarshal(b, &fields)
+	return fields, err
+}
+
+// write the audit record once
+func audit_write(exit_code int) {
+	audit_once.Do(func() {
+		audit_mutex.Lock()
+		defer audit_mutex.Unlock()
+
+		audit.ExitCode = exit_code
+		audit.DurationSeconds = time.Since(audit.StartedAt).Seconds()
+		audit.JobName = config.job_name
+		audit.BuildID = config.build_id
+		audit.Mounts = config.volumes
+		audit.EnvKeys = environment_keys(config.environment)
+		audit.Images = config.images
+
+		logger := log.New()
+		logger.Formatter = &log.JSONFormatter{}
+		logger.Out = ioutil.Discard
+
+		path := config.audit_log
+		if path == "" {
+			path = default_audit_log
+		}
+		file, err := open_audit_log(path)
+		if err != nil {
+			log.Warnf("Can't open audit log: %s", err)
+		} else {
+			defer file.Close()
+			logger.Out = file
+		}
+
+		if config.audit_syslog {
+			writer, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "jenkins_docker_wrapper")
+			if err != nil {
+				log.Warnf("Can't connect to syslog: %s", err)
+			} else {
+				defer writer.Close()
+				logger.Hooks.Add(&audit_syslog_hook{writer: writer})
+			}
+		}
+
+		fields, err := audit.fields()
+		if err != nil {
+			log.Warnf("Can't serialize audit record: %s", err)
+			return
+		}
+		logger.WithFields(fields).Info("invocation")
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditEnvironmentKeys(t *testing.T) {
+	keys := environment_keys([]string{
+		"USER=jenkins",
+		"SECRET_TOKEN=abc=def",
+		"EMPTY=",
+	})
+	assert.Equal(t, []string{"EMPTY", "SECRET_TOKEN", "USER"}, keys, "Keys not correct")
+}
+
+func TestAuditPolicy(t *testing.T) {
+	audit = audit_record{}
+
+	audit_policy("user", "jenkins", nil)
+	audit_policy("workspace", "/tmp", errors.New("not within workspace"))
+
+	assert.Equal(
+		t,
+		[]policy_decision{
+			{Check: "user", Subject: "jenkins", Allowed: true},
+			{Check: "workspace", Subject: "/tmp", Allowed: false, Reason: "not within workspace"},
+		},
+		audit.PolicyDecisions,
+		"Policy decisions not recorded",
+	)
+
+	fields, err := audit.fields()
+	assert.Equal(t, nil, err, "Do not return a error")
+	_, ok := fields["policy_decisions"]
+	assert.Equal(t, true, ok, "Policy decisions missing in fields")
+}
+
+func TestAuditOpenLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	file, err := open_audit_log(path)
+	assert.Equal(t, nil, err, "Do not return a error")
+	file.Close()
+
+	stat, err := os.Stat(path)
+	assert.Equal(t, nil, err, "Audit log not created")
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm(), "Audit log must only be readable by owner")
+}
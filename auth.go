@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"github.com/danryan/go-group/os/group"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// identity of the calling user, from the real uid and gid, never the environment
+type caller struct {
+	username string
+	uid      int
+	gid      int
+	home     string
+	groups   []string
+	gids     []int
+}
+
+// look up the calling user by the real uid and gid
+func lookup_caller() (*caller, error) {
+	uid, gid := os.Getuid(), os.Getgid()
+	user_struct, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &caller{
+		username: user_struct.Username,
+		uid:      uid,
+		gid:      gid,
+		home:     user_struct.HomeDir,
+		groups:   []string{},
+		gids:     []int{},
+	}
+
+	gids, err := user_struct.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	gids = append(gids, strconv.Itoa(gid))
+	for _, gid_str := range gids {
+		group_struct, err := group.LookupGroupId(gid_str)
+		if err != nil {
+			return nil, err
+		}
+		if !string_in_slice(group_struct.Name, c.groups) {
+			c.groups = append(c.groups, group_struct.Name)
+			gid, err := strconv.Atoi(gid_str)
+			if err != nil {
+				return nil, err
+			}
+			c.gids = append(c.gids, gid)
+		}
+	}
+	return c, nil
+}
+
+// test if the caller is one of the allowed users or member of an allowed group
+func check_caller_allowed(c *caller, allowed_users []string, allowed_groups []string) error {
+	if c.uid == 0 {
+		return fmt.Errorf("User '%s' is root, builds must not run as root", c.username)
+	}
+	if string_in_slice(c.username, allowed_users) {
+		return nil
+	}
+	for _, name := range c.groups {
+		if string_in_slice(name, allowed_groups) {
+			return nil
+		}
+	}
+	return fmt.Errorf("User '%s' (uid %d) is not allowed to run builds", c.username, c.uid)
+}
+
+// authenticate the caller, the build runs as this user
+func authenticate_caller() error {
+	c, err := lookup_caller()
+	if err != nil {
+		audit_policy("caller", strconv.Itoa(os.Getuid()), err)
+		return err
+	}
+
+	audit_mutex.Lock()
+	audit.User = c.username
+	audit_mutex.Unlock()
+
+	err = check_caller_allowed(c, config.allowed_users, config.allowed_user_groups)
+	audit_policy("caller", c.username, err)
+	if err != nil {
+		return err
+	}
+
+	config.caller = c
+	config.jenkins_user = c.username
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/user"
+	"testing"
+)
+
+func TestLookupCaller(t *testing.T) {
+	current, err := user.Current()
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	c, err := lookup_caller()
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, current.Username, c.username, "Caller not identified by the real uid")
+	assert.Equal(t, os.Getgid(), c.gid, "Caller gid not the real gid")
+	assert.Equal(t, current.HomeDir, c.home, "Caller home not from the passwd entry")
+}
+
+func TestCheckCallerAllowed(t *testing.T) {
+	c := &caller{username: "jenkins", uid: 1000, gid: 1000, groups: []string{"jenkins", "ci"}}
+
+	assert.Equal(t, nil, check_caller_allowed(c, []string{"jenkins"}, nil), "Allowed user denied")
+	assert.Equal(t, nil, check_caller_allowed(c, nil, []string{"ci"}), "Member of allowed group denied")
+	assert.NotEqual(t, nil, check_caller_allowed(c, []string{"other"}, []string{"builders"}), "Other user allowed")
+
+	root := &caller{username: "root", uid: 0, gid: 0, groups: []string{"root"}}
+	assert.NotEqual(t, nil, check_caller_allowed(root, []string{"root"}, nil), "Root allowed")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// directory of the injected certificates in the container
+const ca_dir = "/etc/jenkins_docker_wrapper"
+
+// injected certificates only, for NODE_EXTRA_CA_CERTS
+var ca_certificates_path = filepath.Join(ca_dir, "ca-certificates.crt")
+
+// system certificates of the image plus the injected ones
+var ca_bundle_path = filepath.Join(ca_dir, "ca-bundle.crt")
+
+// system bundles of the distributions, the first found is extended
+var ca_system_bundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// trust store of an image family
+type ca_trust_store struct {
+	name    string   // Tool updating the trust store
+	anchors string   // Directory of additional certificates
+	command []string // Command registering the certificates
+}
+
+var ca_trust_debian = &ca_trust_store{
+	name:    "update-ca-certificates",
+	anchors: "/usr/local/share/ca-certificates",
+	command: []string{"update-ca-certificates"},
+}
+
+var ca_trust_redhat = &ca_trust_store{
+	name:    "update-ca-trust",
+	anchors: "/etc/pki/ca-trust/source/anchors",
+	command: []string{"update-ca-trust", "extract"},
+}
+
+// distributions by their os-release ID
+var ca_trust_stores = map[string]*ca_trust_store{
+	"debian":    ca_trust_debian,
+	"ubuntu":    ca_trust_debian,
+	"alpine":    ca_trust_debian,
+	"rhel":      ca_trust_redhat,
+	"centos":    ca_trust_redhat,
+	"fedora":    ca_trust_redhat,
+	"amzn":      ca_trust_redhat,
+	"ol":        ca_trust_redhat,
+	"rocky":     ca_trust_redhat,
+	"almalinux": ca_trust_redhat,
+}
+
+// read and validate the certificates of the config file into one PEM bundle
+func read_ca_certificates(paths []string) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		count := 0
+		for {
+			var block *pem.Block
+			block, content = pem.Decode(content)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				return nil, fmt.Errorf("Invalid CA certificate file %s, unexpected %s", path, block.Type)
+			}
+			_, err = x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid CA certificate file %s: %s", path, err)
+			}
+			pem.Encode(&buf, block)
+			count++
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("Invalid CA certificate file %s, no certificate found", path)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// environment pointing tools without a system trust store to the injected certificates
+func ca_environment() []string {
+	return []string{
+		fmt.Sprintf("SSL_CERT_FILE=%s", ca_bundle_path),
+		fmt.Sprintf("REQUESTS_CA_BUNDLE=%s", ca_bundle_path),
+		fmt.Sprintf("NODE_EXTRA_CA_CERTS=%s", ca_certificates_path),
+	}
+}
+
+// trust store of the image from the ID and ID_LIKE of its os-release, nil if unknown
+func detect_ca_trust_store(os_release []byte) *ca_trust_store {
+	ids := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(os_release))
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(parts) != 2 || (parts[0] != "ID" && parts[0] != "ID_LIKE") {
+			continue
+		}
+		ids = append(ids, strings.Fields(strings.Trim(parts[1], `"'`))...)
+	}
+	for _, id := range ids {
+		if store, ok := ca_trust_stores[id]; ok {
+			return store
+		}
+	}
+	return nil
+}
+
+// certificates of a build container
+type ca_injection struct {
+	dw    *docker_wrapper.DockerWrapper
+	store *ca_trust_store
+}
+
+// inject the certificates into the created container
+func inject_ca_certificates(dw *docker_wrapper.DockerWrapper, certificates []byte) (*ca_injection, error) {
+	if len(certificates) == 0 {
+		return nil, nil
+	}
+
+	os_release, err := download_image_file(dw, "/etc/os-release")
+	if err != nil {
+		return nil, err
+	}
+	c := &ca_injection{dw: dw, store: detect_ca_trust_store(os_release)}
+
+	// extend the system bundle for the environment variables
+	var system []byte
+	for _, path := range ca_system_bundles {
+		system, err = download_image_file(dw, path)
+		if err != nil {
+			return nil, err
+		}
+		if len(system) > 0 {
+			break
+		}
+	}
+	if len(system) > 0 && system[len(system)-1] != '\n' {
+		system = append(system, '\n')
+	}
+
+	entries := []archive_entry{
+		{name: strings.TrimPrefix(ca_dir, "/"), dir: true, mode: 0755},
+		{name: strings.TrimPrefix(ca_certificates_path, "/"), mode: 0644, content: certificates},
+		{name: strings.TrimPrefix(ca_bundle_path, "/"), mode: 0644, content: append(system, certificates...)},
+	}
+	if c.store != nil {
+		entries = append(entries, archive_entry{
+			name:    strings.TrimPrefix(filepath.Join(c.store.anchors, "jenkins_docker_wrapper.crt"), "/"),
+			mode:    0644,
+			content: certificates,
+		})
+	}
+
+	archive, err := build_archive(entries)
+	if err != nil {
+		return nil, err
+	}
+	err = dw.UploadArchive("/", archive)
+	if err != nil {
+		return nil, fmt.Errorf("Can't inject CA certificates: %s", err)
+	}
+	return c, nil
+}
+
+// register the certificates with the trust store of the started container
+func (c *ca_injection) update() error {
+	if c == nil {
+		return nil
+	}
+	if c.store == nil {
+		log.Debugf("No trust store detected, CA certificates are only set by environment")
+		return nil
+	}
+
+	// images without the tool keep the environment variables only
+	probe := []string{"sh", "-c", fmt.Sprintf("command -v %s", c.store.command[0])}
+	_, _, ret_val, err := c.dw.RunCommandUser(probe, provisioning_exec_user)
+	if err != nil {
+		return err
+	}
+	if ret_val != 0 {
+		log.Infof("No %s in the image, CA certificates are only set by environment", c.store.name)
+		return nil
+	}
+
+	_, stderr, ret_val, err := c.dw.RunCommandUser(c.store.command, provisioning_exec_user)
+	if err != nil {
+		return err
+	}
+	if ret_val != 0 {
+		return fmt.Errorf("%s failed with exit code %d: %s", c.store.name, ret_val, strings.TrimSpace(stderr))
+	}
+	log.Debugf("Registered CA certificates with %s", c.store.name)
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// self signed CA certificate in PEM
+func test_ca_certificate(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Equal(t, nil, err, "Do not return a error")
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Equal(t, nil, err, "Do not return a error")
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestReadCaCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+
+	cert := test_ca_certificate(t)
+	ioutil.WriteFile(filepath.Join(dir, "ca.crt"), append([]byte("# corporate CA\n"), cert...), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "key.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("x")}), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "empty.crt"), []byte("no certificate"), 0644)
+
+	bundle, err := read_ca_certificates([]string{filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.crt")})
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, append(cert, cert...), bundle, "Certificates not bundled")
+
+	_, err = read_ca_certificates([]string{filepath.Join(dir, "key.pem")})
+	assert.NotEqual(t, nil, err, "Private key accepted as certificate")
+
+	_, err = read_ca_certificates([]string{filepath.Join(dir, "empty.crt")})
+	assert.NotEqual(t, nil, err, "File without certificate accepted")
+
+	_, err = read_ca_certificates([]string{filepath.Join(dir, "missing.crt")})
+	assert.NotEqual(t, nil, err, "Missing file accepted")
+}
+
+func TestDetectCaTrustStore(t *testing.T) {
+	assert.Equal(t, ca_trust_debian, detect_ca_trust_store([]byte("NAME=\"Alpine Linux\"\nID=alpine\n")), "Alpine not detected")
+	assert.Equal(t, ca_trust_debian, detect_ca_trust_store([]byte("ID=linuxmint\nID_LIKE=\"ubuntu debian\"\n")), "Derivative not detected by ID_LIKE")
+	assert.Equal(t, ca_trust_redhat, detect_ca_trust_store([]byte("ID=\"centos\"\nID_LIKE=\"rhel fedora\"\n")), "CentOS not detected")
+	assert.Equal(t, (*ca_trust_store)(nil), detect_ca_trust_store([]byte("ID=distroless\n")), "Unknown distribution detected")
+	assert.Equal(t, (*ca_trust_store)(nil), detect_ca_trust_store([]byte{}), "Image without os-release detected")
+}
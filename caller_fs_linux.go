@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// set the filesystem uid of the current thread and verify it
+func set_thread_fsuid(uid int) error {
+	syscall.RawSyscall(syscall.SYS_SETFSUID, uintptr(uid), 0, 0)
+	// an invalid id returns the current one without changing it
+	current, _, _ := syscall.RawSyscall(syscall.SYS_SETFSUID, ^uintptr(0), 0, 0)
+	if int(current) != uid {
+		return fmt.Errorf("Can't set filesystem uid to %d", uid)
+	}
+	return nil
+}
+
+// set the filesystem gid of the current thread and verify it
+func set_thread_fsgid(gid int) error {
+	syscall.RawSyscall(syscall.SYS_SETFSGID, uintptr(gid), 0, 0)
+	current, _, _ := syscall.RawSyscall(syscall.SYS_SETFSGID, ^uintptr(0), 0, 0)
+	if int(current) != gid {
+		return fmt.Errorf("Can't set filesystem gid to %d", gid)
+	}
+	return nil
+}
+
+// set the supplementary groups of the current thread only
+func set_thread_groups(gids []int) error {
+	list := make([]uint32, len(gids)+1)
+	for i, gid := range gids {
+		list[i] = uint32(gid)
+	}
+	_, _, e := syscall.RawSyscall(syscall.SYS_SETGROUPS, uintptr(len(gids)), uintptr(unsafe.Pointer(&list[0])), 0)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// run fn with the filesystem credentials of the caller, path tricks can't
+// reach files the caller couldn't access itself
+//
+// the credentials are changed for the locked thread only, the docker
+// connection keeps the privileges of the wrapper. fn must not start goroutines.
+func as_caller(fn func() error) error {
+	c := config.caller
+	if c == nil {
+		return fn()
+	}
+
+	runtime.LockOSThread()
+	euid, egid := os.Geteuid(), os.Getegid()
+	privileged := euid == 0
+	saved_groups, err := syscall.Getgroups()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+
+	// only root can change groups and uid, a setgid binary drops its group
+	if privileged {
+		err = set_thread_groups(c.gids)
+	}
+	if err == nil {
+		err = set_thread_fsgid(c.gid)
+	}
+	if err == nil && privileged {
+		err = set_thread_fsuid(c.uid)
+	}
+	if err == nil {
+		err = fn()
+	}
+
+	// restore the credentials, a thread stuck with the caller's stays locked
+	restore_err := set_thread_fsuid(euid)
+	if restore_err == nil {
+		restore_err = set_thread_fsgid(egid)
+	}
+	if restore_err == nil && privileged {
+		restore_err = set_thread_groups(saved_groups)
+	}
+	if restore_err != nil {
+		return fmt.Errorf("Can't restore credentials: %s", restore_err)
+	}
+	runtime.UnlockOSThread()
+	return err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestAsCaller(t *testing.T) {
+	saved := config.caller
+	defer func() { config.caller = saved }()
+
+	// without a caller fn runs unchanged
+	config.caller = nil
+	called := false
+	err := as_caller(func() error {
+		called = true
+		return nil
+	})
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, true, called, "Function not called")
+
+	// the current user as caller keeps the credentials usable
+	config.caller = &caller{
+		uid:  os.Getuid(),
+		gid:  os.Getgid(),
+		gids: []int{os.Getgid()},
+	}
+	fn_err := errors.New("failed")
+	err = as_caller(func() error {
+		_, err := os.Stat(os.TempDir())
+		assert.Equal(t, nil, err, "Do not return a error")
+		return fn_err
+	})
+	assert.Equal(t, fn_err, err, "Error of the function not returned")
+
+	err = as_caller(func() error { return nil })
+	assert.Equal(t, nil, err, "Do not return a error")
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// keys of a compose file that are supported
+var compose_supported_keys = []string{"version", "services"}
+
+// keys of a compose service that are supported
+var compose_service_supported_keys = []string{"image", "environment", "depends_on", "healthcheck"}
+
+// keys that are never allowed, they would give a build access to the host
+var compose_disallowed_keys = []string{
+	"volumes", "volumes_from", "devices", "privileged", "cap_add", "security_opt",
+	"network_mode", "pid", "ipc", "userns_mode", "cgroup_parent", "user", "secrets", "configs",
+	"build", "ports", "networks", "extra_hosts", "sysctls", "tmpfs", "env_file",
+}
+
+// environment of a compose service, in list or map syntax
+type compose_environment map[string]string
+
+// dependencies of a compose service, in list or map syntax
+type compose_depends_on []string
+
+// healthcheck of a compose service
+type compose_healthcheck struct {
+	Test        compose_command `yaml:"test"`
+	Interval    string          `yaml:"interval"`
+	Timeout     string          `yaml:"timeout"`
+	Retries     int             `yaml:"retries"`
+	StartPeriod string          `yaml:"start_period"`
+	Disable     bool            `yaml:"disable"`
+}
+
+// command in string or list syntax
+type compose_command []string
+
+type compose_service struct {
+	Image       string               `yaml:"image"`
+	Environment compose_environment  `yaml:"environment"`
+	DependsOn   compose_depends_on   `yaml:"depends_on"`
+	Healthcheck *compose_healthcheck `yaml:"healthcheck"`
+}
+
+type compose_file struct {
+	Version  string                     `yaml:"version"`
+	Services map[string]compose_service `yaml:"services"`
+}
+
+func (e *compose_environment) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	env := compose_environment{}
+
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		for _, elem := range list {
+			split := strings.SplitN(elem, "=", 2)
+			if len(split) != 2 {
+				return fmt.Errorf("Environment '%s' has no value, passing host variables is not supported", elem)
+			}
+			env[split[0]] = split[1]
+		}
+		*e = env
+		return nil
+	}
+
+	var m map[string]interface{}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+	for key, value := range m {
+		switch value.(type) {
+		case nil:
+			return fmt.Errorf("Environment '%s' has no value, passing host variables is not supported", key)
+		case map[interface{}]interface{}, []interface{}:
+			return fmt.Errorf("Environment '%s' has no scalar value", key)
+		}
+		env[key] = fmt.Sprint(value)
+	}
+	*e = env
+	return nil
+}
+
+func (d *compose_depends_on) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*d = list
+		return nil
+	}
+
+	// long syntax, conditions are ignored as every service is waited for
+	var m map[string]interface{}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+	list = []string{}
+	for key := range m {
+		list = append(list, key)
+	}
+	sort.Strings(list)
+	*d = list
+	return nil
+}
+
+func (c *compose_command) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*c = list
+		return nil
+	}
+
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	*c = []string{"CMD-SHELL", s}
+	return nil
+}
+
+// check the keys of a map against the supported and disallowed keys
+func compose_check_keys(m map[string]interface{}, supported []string, context string) error {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if string_in_slice(key, supported) {
+			continue
+		}
+		if string_in_slice(key, compose_disallowed_keys) {
+			return fmt.Errorf("Key '%s' of %s is not allowed", key, context)
+		}
+		return fmt.Errorf("Key '%s' of %s is not supported", key, context)
+	}
+	return nil
+}
+
+// test if a string is part of a slice
+func string_in_slice(s string, list []string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
+
+func parse_compose_file_io(r io.Reader) (*compose_file, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// reject unsupported keys before interpreting the file
+	var raw map[string]interface{}
+	err = yaml.Unmarshal(b, &raw)
+	if err != nil {
+		return nil, err
+	}
+	err = compose_check_keys(raw, compose_supported_keys, "the compose file")
+	if err != nil {
+		return nil, err
+	}
+	var raw_services struct {
+		Services map[string]map[string]interface{} `yaml:"services"`
+	}
+	err = yaml.Unmarshal(b, &raw_services)
+	if err != nil {
+		return nil, err
+	}
+	for name, service := range raw_services.Services {
+		err = compose_check_keys(service, compose_service_supported_keys, fmt.Sprintf("service '%s'", name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cf := &compose_file{}
+	err = yaml.Unmarshal(b, cf)
+	if err != nil {
+		return nil, err
+	}
+	return cf, nil
+}
+
+func parse_compose_file(path string) (*compose_file, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parse_compose_file_io(file)
+}
+
+// readiness check of a service derived from its healthcheck
+func compose_ready(h *compose_healthcheck) (ServiceReadyConf, error) {
+	ready := ServiceReadyConf{}
+	if h == nil || h.Disable || len(h.Test) == 0 {
+		return ready, nil
+	}
+
+	switch h.Test[0] {
+	case "NONE":
+		return ready, nil
+	case "CMD":
+		ready.Command = h.Test[1:]
+	case "CMD-SHELL":
+		ready.Command = []string{"/bin/sh", "-c", strings.Join(h.Test[1:], " ")}
+	default:
+		return ready, fmt.Errorf("Invalid healthcheck test '%s'", h.Test[0])
+	}
+
+	// the timeout of a single check is not enforced, retries limit the total wait
+	ready.Interval = h.Interval
+	if h.Retries > 0 {
+		interval, err := time.ParseDuration(first_non_empty(h.Interval, default_service_ready_interval))
+		if err != nil {
+			return ready, err
+		}
+		var start_period time.Duration
+		if h.StartPeriod != "" {
+			start_period, err = time.ParseDuration(h.StartPeriod)
+			if err != nil {
+				return ready, err
+			}
+		}
+		ready.Timeout = (start_period + time.Duration(h.Retries)*interval).String()
+	}
+	return ready, nil
+}
+
+// order the services so that dependencies start first
+func compose_service_order(cf *compose_file, build string) ([]string, error) {
+	names := []string{}
+	for name := range cf.Services {
+		if name != build {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	ordered := []string{}
+	state := map[string]int{} // 1 visiting, 2 done
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("Dependency cycle at service '%s'", name)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, dep := range cf.Services[name].DependsOn {
+			if dep == build {
+				return fmt.Errorf("Service '%s' must not depend on the build service '%s'", name, build)
+			}
+			if _, ok := cf.Services[dep]; !ok {
+				return fmt.Errorf("Service '%s' depends on unknown service '%s'", name, dep)
+			}
+			err := visit(dep)
+			if err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		ordered = append(ordered, name)
+		return nil
+	}
+
+	for _, dep := range cf.Services[build].DependsOn {
+		if _, ok := cf.Services[dep]; !ok {
+			return nil, fmt.Errorf("Service '%s' depends on unknown service '%s'", build, dep)
+		}
+	}
+	for _, name := range names {
+		err := visit(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// image, environment and sidecar services of a build in a compose service
+func compose_build(cf *compose_file, build string) (string, []string, []ServiceConf, error) {
+	service, ok := cf.Services[build]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("Service '%s' not found in the compose file", build)
+	}
+	if service.Image == "" {
+		return "", nil, nil, fmt.Errorf("No image given for service '%s'", build)
+	}
+
+	// the wrapper controls some variables of the build container
+	handlers := build_environment_handlers()
+	env := []string{}
+	for _, elem := range service_environment(service.Environment) {
+		key := strings.SplitN(elem, "=", 2)[0]
+		if _, ok := handlers[key]; ok {
+			err := fmt.Errorf("Environment '%s' of service '%s' is not allowed", key, build)
+			audit_policy("compose_environment", key, err)
+			return "", nil, nil, err
+		}
+		env = append(env, elem)
+	}
+
+	order, err := compose_service_order(cf, build)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	services := []ServiceConf{}
+	for _, name := range order {
+		ready, err := compose_ready(cf.Services[name].Healthcheck)
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("Invalid healthcheck of service '%s': %s", name, err)
+		}
+		services = append(services, ServiceConf{
+			Name:        name,
+			Image:       cf.Services[name].Image,
+			Environment: cf.Services[name].Environment,
+			Ready:       ready,
+		})
+	}
+	return service.Image, env, services, nil
+}
+
+// read the compose file of the workspace into the projekt config
+func apply_compose_file(pc *ProjektConf, path string, build string) ([]string, error) {
+	if build == "" {
+		return nil, errors.New("No build service given, use --compose_service")
+	}
+	if len(*args.image_names) > 0 {
+		return nil, errors.New("--image_name can't be combined with --compose_file")
+	}
+
+	path, err := workspace_file_path(path)
+	if err != nil {
+		return nil, err
+	}
+	var cf *compose_file
+	err = as_caller(func() (err error) {
+		cf, err = parse_compose_file(path)
+		return err
+	})
+	audit_policy("compose_file", path, err)
+	if err != nil {
+		return nil, err
+	}
+
+	image, env, services, err := compose_build(cf, build)
+	if err != nil {
+		return nil, err
+	}
+	pc.ImageName = image
+	pc.Matrix = nil
+	pc.Services = services
+	return env, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const compose_example = `
+version: "2.1"
+services:
+  build:
+    image: golang:1.7
+    environment:
+      - GOPATH=/go
+    depends_on:
+      - db
+  db:
+    image: postgres:9.5
+    environment:
+      POSTGRES_DB: test
+      POSTGRES_PORT: 5432
+    depends_on:
+      cache:
+        condition: service_healthy
+    healthcheck:
+      test: ["CMD", "pg_isready"]
+      interval: 2s
+      retries: 10
+  cache:
+    image: redis
+    healthcheck:
+      test: redis-cli ping
+`
+
+func TestParseComposeFileIo(t *testing.T) {
+	cf, err := parse_compose_file_io(strings.NewReader(compose_example))
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, 3, len(cf.Services), "Not every service parsed")
+	assert.Equal(t, "5432", cf.Services["db"].Environment["POSTGRES_PORT"], "Map environment not parsed")
+	assert.Equal(t, "/go", cf.Services["build"].Environment["GOPATH"], "List environment not parsed")
+	assert.Equal(t, compose_depends_on{"cache"}, cf.Services["db"].DependsOn, "Long depends_on syntax not parsed")
+	assert.Equal(t, compose_command{"CMD-SHELL", "redis-cli ping"}, cf.Services["cache"].Healthcheck.Test, "String test not parsed")
+}
+
+func TestParseComposeFileIoDisallowed(t *testing.T) {
+	_, err := parse_compose_file_io(strings.NewReader("services:\n  build:\n    image: debian\n    privileged: true\n"))
+	assert.NotEqual(t, nil, err, "Privileged service not rejected")
+	assert.Contains(t, err.Error(), "not allowed", "Error does not explain the rejection")
+
+	_, err = parse_compose_file_io(strings.NewReader("services:\n  build:\n    image: debian\n    volumes:\n      - /:/host\n"))
+	assert.NotEqual(t, nil, err, "Host mount not rejected")
+
+	_, err = parse_compose_file_io(strings.NewReader("services:\n  build:\n    image: debian\n    stop_signal: SIGINT\n"))
+	assert.NotEqual(t, nil, err, "Unsupported key not rejected")
+	assert.Contains(t, err.Error(), "not supported", "Error does not explain the rejection")
+
+	_, err = parse_compose_file_io(strings.NewReader("networks:\n  default: {}\nservices: {}\n"))
+	assert.NotEqual(t, nil, err, "Top level networks not rejected")
+
+	_, err = parse_compose_file_io(strings.NewReader("services:\n  build:\n    image: debian\n    environment:\n      - HOST_VAR\n"))
+	assert.NotEqual(t, nil, err, "Host environment pass through not rejected")
+}
+
+func TestComposeBuild(t *testing.T) {
+	cf, err := parse_compose_file_io(strings.NewReader(compose_example))
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	image, env, services, err := compose_build(cf, "build")
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "golang:1.7", image, "Image of the build service not used")
+	assert.Equal(t, []string{"GOPATH=/go"}, env, "Environment of the build service not used")
+	assert.Equal(t, 2, len(services), "Sidecars not correct")
+	assert.Equal(t, "cache", services[0].Name, "Dependency not started first")
+	assert.Equal(t, []string{"/bin/sh", "-c", "redis-cli ping"}, services[0].Ready.Command, "Shell healthcheck not converted")
+	assert.Equal(t, []string{"pg_isready"}, services[1].Ready.Command, "Healthcheck not converted")
+	assert.Equal(t, "20s", services[1].Ready.Timeout, "Retries not converted to a timeout")
+
+	_, _, _, err = compose_build(cf, "missing")
+	assert.NotEqual(t, nil, err, "Missing build service not detected")
+
+	cf, _ = parse_compose_file_io(strings.NewReader("services:\n  build:\n    image: debian\n    environment:\n      USER: root\n"))
+	_, _, _, err = compose_build(cf, "build")
+	assert.NotEqual(t, nil, err, "Controlled environment not rejected")
+}
+
+func TestComposeServiceOrder(t *testing.T) {
+	cf, _ := parse_compose_file_io(strings.NewReader("services:\n  build: {image: debian}\n  a: {image: a, depends_on: [b]}\n  b: {image: b, depends_on: [a]}\n"))
+	_, err := compose_service_order(cf, "build")
+	assert.NotEqual(t, nil, err, "Dependency cycle not detected")
+
+	cf, _ = parse_compose_file_io(strings.NewReader("services:\n  build: {image: debian}\n  a: {image: a, depends_on: [build]}\n"))
+	_, err = compose_service_order(cf, "build")
+	assert.NotEqual(t, nil, err, "Dependency on the build service not detected")
+
+	cf, _ = parse_compose_file_io(strings.NewReader("services:\n  build: {image: debian}\n  a: {image: a, depends_on: [missing]}\n"))
+	_, err = compose_service_order(cf, "build")
+	assert.NotEqual(t, nil, err, "Unknown dependency not detected")
+}
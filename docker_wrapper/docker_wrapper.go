@@ -0,0 +1,443 @@
+package docker_wrapper
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/fsouza/go-dockerclient"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+type DockerWrapper struct {
+	client         DockerClientInterface
+	DefaultRunCmd  []string
+	Volumes        []string
+	ImageName      string
+	ContainerName  string
+	WorkingDir     string
+	Environment    []string
+	Labels         map[string]string
+	NetworkMode    string            // Network to connect the container to
+	NetworkAliases []string          // Host names of the container in the network
+	User           string            // User and group the container runs as
+	SecurityOpt    []string          // Security options of the container
+	GroupAdd       []string          // Additional groups of the container user
+	Tmpfs          map[string]string // Tmpfs mounts with their options
+	DNS            []string          // Name servers of the container
+	DNSSearch      []string          // Search domains of the container
+	ExtraHosts     []string          // Additional host:ip entries of /etc/hosts
+	Stdin          io.Reader         // Input of attached commands, not attached if nil
+	Stdout         io.Writer         // Output of attached commands, defaults to os.Stdout
+	Stderr         io.Writer         // Errors of attached commands, defaults to os.Stderr
+	container      *docker.Container
+}
+
+type DockerClientInterface interface {
+	CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error)
+	StartExec(id string, opts docker.StartExecOptions) error
+	InspectExec(id string) (*docker.ExecInspect, error)
+	StartContainer(id string, hostConfig *docker.HostConfig) error
+	StopContainer(id string, timeout uint) error
+	WaitContainer(id string) (int, error)
+	CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error)
+	RemoveContainer(opts docker.RemoveContainerOptions) error
+	AttachToContainer(opts docker.AttachToContainerOptions) error
+	InspectContainer(id string) (*docker.Container, error)
+	AddEventListener(listener chan<- *docker.APIEvents) error
+	RemoveEventListener(listener chan *docker.APIEvents) error
+	Stats(opts docker.StatsOptions) error
+	InspectImage(name string) (*docker.Image, error)
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	CommitContainer(opts docker.CommitContainerOptions) (*docker.Image, error)
+	ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error)
+	RemoveImage(name string) error
+	CreateNetwork(opts docker.CreateNetworkOptions) (*docker.Network, error)
+	RemoveNetwork(id string) error
+	DisconnectNetwork(id string, opts docker.NetworkConnectionOptions) error
+	BuildImage(opts docker.BuildImageOptions) error
+	DownloadFromContainer(id string, opts docker.DownloadFromContainerOptions) error
+	UploadToContainer(id string, opts docker.UploadToContainerOptions) error
+}
+
+func New() (*DockerWrapper, error) {
+	endpoint := "unix:///var/run/docker.sock"
+	client, _ := docker.NewClient(endpoint)
+	version, err := client.Version()
+	if err != nil {
+		log.Panicf("Docker connection not successful: %s", err)
+	}
+	log.Debugf("Docker connection successful. server version: %s\n", version.Get("Version"))
+	dw := NewOffline()
+	dw.client = client
+	return dw, nil
+}
+
+// wrapper without docker connection, only usable to generate options
+func NewOffline() *DockerWrapper {
+	return &DockerWrapper{
+		DefaultRunCmd: []string{"cat"},
+		ContainerName: "",
+	}
+}
+
+func (dw *DockerWrapper) RunCommandRetval(e_id string) int {
+	// get return code
+	execInspect, err := dw.client.InspectExec(e_id)
+	if err != nil {
+		return -1
+	} else {
+		return execInspect.ExitCode
+	}
+}
+
+func (dw *DockerWrapper) RunCommandAttach(command []string, tty bool) (ret_val int, err error) {
+	create_config := docker.CreateExecOptions{
+		Container:    dw.container.ID,
+		AttachStdin:  dw.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          tty,
+		Cmd:          command,
+	}
+
+	execObj, err := dw.client.CreateExec(create_config)
+	if err != nil {
+		return -1, err
+	}
+
+	var stdout io.Writer = os.Stdout
+	if dw.Stdout != nil {
+		stdout = dw.Stdout
+	}
+	var stderr io.Writer = os.Stderr
+	if dw.Stderr != nil {
+		stderr = dw.Stderr
+	}
+
+	start_config := docker.StartExecOptions{
+		InputStream:  dw.Stdin,
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+		Detach:       false,
+		RawTerminal:  tty,
+		Tty:          tty,
+	}
+	err = dw.client.StartExec(execObj.ID, start_config)
+	if err != nil {
+		return -1, err
+	}
+
+	return dw.RunCommandRetval(execObj.ID), nil
+
+}
+
+func (dw *DockerWrapper) RunCommand(command []string) (stdout string, stderr string, ret_val int, err error) {
+	return dw.RunCommandUser(command, "")
+}
+
+// run a command as a different user than the container user
+func (dw *DockerWrapper) RunCommandUser(command []string, user string) (stdout string, stderr string, ret_val int, err error) {
+	return dw.RunCommandInput(command, user, nil)
+}
+
+// run a command reading its standard input from input, an empty user runs as the container user
+func (dw *DockerWrapper) RunCommandInput(command []string, user string, input io.Reader) (stdout string, stderr string, ret_val int, err error) {
+	// prepare container
+	create_config := docker.CreateExecOptions{
+		Container:    dw.container.ID,
+		User:         user,
+		AttachStdin:  input != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          false,
+		Cmd:          command,
+	}
+
+	execObj, err := dw.client.CreateExec(create_config)
+	if err != nil {
+		return "", "", -1, err
+	}
+
+	buf_stdout := new(bytes.Buffer)
+	buf_stderr := new(bytes.Buffer)
+
+	start_config := docker.StartExecOptions{
+		InputStream:  input,
+		OutputStream: buf_stdout,
+		ErrorStream:  buf_stderr,
+		RawTerminal:  false,
+		Tty:          false,
+	}
+
+	err = dw.client.StartExec(execObj.ID, start_config)
+	if err != nil {
+		return "", "", -1, err
+	}
+
+	ret_val = dw.RunCommandRetval(execObj.ID)
+
+	return buf_stdout.String(), buf_stderr.String(), ret_val, err
+}
+
+// run a always running container
+func (dw *DockerWrapper) Run() (err error) {
+
+	// create
+	dw.container, err = dw.create()
+	if err != nil {
+		return err
+	}
+
+	// start container
+	err = dw.client.StartContainer(dw.container.ID, dw.get_host_config())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// create the container without starting it
+func (dw *DockerWrapper) Create() (err error) {
+	dw.container, err = dw.create()
+	return err
+}
+
+// read a single file from the container
+func (dw *DockerWrapper) DownloadFile(path string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := dw.client.DownloadFromContainer(dw.container.ID, docker.DownloadFromContainerOptions{
+		Path:         path,
+		OutputStream: buf,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reader := tar.NewReader(buf)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("File '%s' not found in archive", path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+			return ioutil.ReadAll(reader)
+		}
+	}
+}
+
+// extract a tar archive into a directory of the container
+func (dw *DockerWrapper) UploadArchive(path string, archive io.Reader) error {
+	return dw.client.UploadToContainer(dw.container.ID, docker.UploadToContainerOptions{
+		Path:        path,
+		InputStream: archive,
+	})
+}
+
+// id of the running container
+func (dw *DockerWrapper) ContainerID() string {
+	if dw.container == nil {
+		return ""
+	}
+	return dw.container.ID
+}
+
+// inspect the running container
+func (dw *DockerWrapper) Inspect() (*docker.Container, error) {
+	return dw.client.InspectContainer(dw.container.ID)
+}
+
+// inspect a container by id
+func (dw *DockerWrapper) InspectContainerID(id string) (*docker.Container, error) {
+	return dw.client.InspectContainer(id)
+}
+
+// inspect the image of the container
+func (dw *DockerWrapper) InspectImage() (*docker.Image, error) {
+	return dw.client.InspectImage(dw.ImageName)
+}
+
+// subscribe to docker events
+func (dw *DockerWrapper) AddEventListener(listener chan<- *docker.APIEvents) error {
+	return dw.client.AddEventListener(listener)
+}
+
+// unsubscribe from docker events
+func (dw *DockerWrapper) RemoveEventListener(listener chan *docker.APIEvents) error {
+	return dw.client.RemoveEventListener(listener)
+}
+
+// stream resource usage of the running container until done is closed
+func (dw *DockerWrapper) Stats(stats chan<- *docker.Stats, done <-chan bool) error {
+	return dw.client.Stats(docker.StatsOptions{
+		ID:     dw.container.ID,
+		Stats:  stats,
+		Stream: true,
+		Done:   done,
+	})
+}
+
+// list all containers matching all labels
+func (dw *DockerWrapper) ListContainersByLabel(labels ...string) ([]docker.APIContainers, error) {
+	return dw.client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": labels},
+	})
+}
+
+// use an existing container
+func (dw *DockerWrapper) Use(id string) (err error) {
+	dw.container, err = dw.client.InspectContainer(id)
+	if err != nil {
+		return err
+	}
+	dw.ImageName = dw.container.Config.Image
+	return nil
+}
+
+// start the container if it is not running, returns true if it was started
+func (dw *DockerWrapper) Start() (bool, error) {
+	container, err := dw.client.InspectContainer(dw.container.ID)
+	if err != nil {
+		return false, err
+	}
+	if container.State.Running {
+		return false, nil
+	}
+	return true, dw.client.StartContainer(dw.container.ID, nil)
+}
+
+// remove a container by id
+func (dw *DockerWrapper) RemoveContainerID(id string) error {
+	return dw.client.RemoveContainer(docker.RemoveContainerOptions{ID: id})
+}
+
+// commit the container to an image
+func (dw *DockerWrapper) Commit(repository string, tag string, labels map[string]string) (*docker.Image, error) {
+	return dw.client.CommitContainer(docker.CommitContainerOptions{
+		Container:  dw.container.ID,
+		Repository: repository,
+		Tag:        tag,
+		Run:        &docker.Config{Labels: labels},
+	})
+}
+
+// list all images with a label
+func (dw *DockerWrapper) ListImagesByLabel(label string) ([]docker.APIImages, error) {
+	return dw.client.ListImages(docker.ListImagesOptions{
+		Filters: map[string][]string{"label": []string{label}},
+	})
+}
+
+// remove an image by name or id
+func (dw *DockerWrapper) RemoveImage(name string) error {
+	return dw.client.RemoveImage(name)
+}
+
+// build an image from a Dockerfile in a tar archive of the context
+func (dw *DockerWrapper) BuildImage(name string, dockerfile string, context io.Reader, output io.Writer) error {
+	return dw.client.BuildImage(docker.BuildImageOptions{
+		Name:                name,
+		Dockerfile:          dockerfile,
+		InputStream:         context,
+		RmTmpContainer:      true,
+		ForceRmTmpContainer: true,
+		OutputStream:        output,
+	})
+}
+
+// create a network
+func (dw *DockerWrapper) CreateNetwork(name string, labels map[string]string) (*docker.Network, error) {
+	return dw.client.CreateNetwork(docker.CreateNetworkOptions{
+		Name:           name,
+		CheckDuplicate: true,
+		Labels:         labels,
+	})
+}
+
+// remove a network
+func (dw *DockerWrapper) RemoveNetwork(id string) error {
+	return dw.client.RemoveNetwork(id)
+}
+
+// disconnect the container from a network
+func (dw *DockerWrapper) DisconnectNetwork(id string) error {
+	return dw.client.DisconnectNetwork(id, docker.NetworkConnectionOptions{
+		Container: dw.container.ID,
+		Force:     true,
+	})
+}
+
+func (dw *DockerWrapper) Stop() error {
+
+	// stop container
+	dw.client.StopContainer(dw.container.ID, 2)
+
+	// wait for container
+	dw.client.WaitContainer(dw.container.ID)
+	return nil
+
+}
+
+// generate host config
+func (dw *DockerWrapper) get_host_config() *docker.HostConfig {
+	var config docker.HostConfig
+	config.Binds = dw.Volumes
+	config.RestartPolicy = docker.NeverRestart()
+	config.NetworkMode = dw.NetworkMode
+	config.SecurityOpt = dw.SecurityOpt
+	config.GroupAdd = dw.GroupAdd
+	config.Tmpfs = dw.Tmpfs
+	config.DNS = dw.DNS
+	config.DNSSearch = dw.DNSSearch
+	config.ExtraHosts = dw.ExtraHosts
+	return &config
+}
+
+// create the container
+func (dw *DockerWrapper) create() (container *docker.Container, err error) {
+	copts := dw.CreateOptions()
+	log.Debugf("Create options image=%s command=%s", copts.Config.Image, copts.Config.Cmd)
+	return dw.client.CreateContainer(copts)
+}
+
+// generate the options to create the container
+func (dw *DockerWrapper) CreateOptions() docker.CreateContainerOptions {
+	var c_config docker.Config
+	c_config.Image = dw.ImageName
+	c_config.Cmd = dw.DefaultRunCmd
+	c_config.WorkingDir = dw.WorkingDir
+	c_config.Tty = true
+	c_config.OpenStdin = true
+	c_config.Env = dw.Environment
+	c_config.Labels = dw.Labels
+	c_config.User = dw.User
+	host_config := dw.get_host_config()
+
+	var copts docker.CreateContainerOptions
+	copts.Name = dw.ContainerName
+	copts.Config = &c_config
+	copts.HostConfig = host_config
+	if dw.NetworkMode != "" && len(dw.NetworkAliases) > 0 {
+		copts.NetworkingConfig = &docker.NetworkingConfig{
+			EndpointsConfig: map[string]*docker.EndpointConfig{
+				dw.NetworkMode: &docker.EndpointConfig{Aliases: dw.NetworkAliases},
+			},
+		}
+	}
+
+	return copts
+}
+
+// remove a container
+func (dw *DockerWrapper) Remove() (err error) {
+	var opts docker.RemoveContainerOptions
+	opts.ID = dw.container.ID
+	return dw.client.RemoveContainer(opts)
+}
@@ -0,0 +1,49 @@
+package docker_wrapper
+
+import (
+	"bytes"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// client recording the options of an exec
+type fake_exec_client struct {
+	DockerClientInterface
+	create docker.CreateExecOptions
+	start  docker.StartExecOptions
+}
+
+func (c *fake_exec_client) CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error) {
+	c.create = opts
+	return &docker.Exec{ID: "exec1"}, nil
+}
+
+func (c *fake_exec_client) StartExec(id string, opts docker.StartExecOptions) error {
+	c.start = opts
+	return nil
+}
+
+func (c *fake_exec_client) InspectExec(id string) (*docker.ExecInspect, error) {
+	return &docker.ExecInspect{ExitCode: 3}, nil
+}
+
+func TestRunCommandAttachStdin(t *testing.T) {
+	client := &fake_exec_client{}
+	dw := &DockerWrapper{client: client, container: &docker.Container{ID: "container1"}}
+
+	// the build gets no input
+	ret_val, err := dw.RunCommandAttach([]string{"sh"}, false)
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, 3, ret_val, "Exit code not returned")
+	assert.Equal(t, false, client.create.AttachStdin, "Stdin attached without input")
+
+	// piped input is attached without a terminal
+	input := bytes.NewBufferString("echo hello\n")
+	dw.Stdin = input
+	_, err = dw.RunCommandAttach([]string{"sh"}, false)
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, true, client.create.AttachStdin, "Stdin not attached without a terminal")
+	assert.Equal(t, false, client.create.Tty, "Terminal allocated for piped input")
+	assert.Equal(t, input, client.start.InputStream, "Input not passed to the exec")
+}
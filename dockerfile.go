@@ -0,0 +1,435 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/fileutils"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"github.com/fsouza/go-dockerclient"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// repository of images built from workspace Dockerfiles
+const build_image_repository = "jenkins_docker_wrapper_build"
+
+// length of the content hash in the image tag
+const build_image_hash_length = 16
+
+// name of an image built from a workspace Dockerfile
+var valid_build_image = regexp.MustCompile(fmt.Sprintf("^%s/[^:/]+:[0-9a-f]{%d}$", build_image_repository, build_image_hash_length))
+
+// parser directive at the top of a Dockerfile
+var dockerfile_directive = regexp.MustCompile(`^#\s*([a-zA-Z][a-zA-Z0-9]*)\s*=\s*(.+?)\s*$`)
+
+// base images of the stages of a Dockerfile, references to earlier stages are skipped,
+// the frontend image of the syntax directive is returned as well
+func dockerfile_base_images(r io.Reader) ([]string, error) {
+	images := []string{}
+	stages := map[string]bool{}
+	count := 0
+	escape := "\\"
+	directives := map[string]bool{}
+	in_directives := true
+
+	scanner := bufio.NewScanner(r)
+	line := ""
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+
+		// directives are only read before the first comment, empty line or instruction
+		if in_directives {
+			match := dockerfile_directive.FindStringSubmatch(text)
+			if match == nil {
+				in_directives = false
+			} else {
+				key := strings.ToLower(match[1])
+				if directives[key] {
+					return nil, fmt.Errorf("Parser directive '%s' repeated in Dockerfile", key)
+				}
+				directives[key] = true
+				switch key {
+				case "escape":
+					if match[2] != "\\" && match[2] != "`" {
+						return nil, fmt.Errorf("Invalid escape character '%s' in Dockerfile", match[2])
+					}
+					escape = match[2]
+				case "syntax":
+					if strings.Contains(match[2], "$") {
+						return nil, fmt.Errorf("Frontend image '%s' uses build arguments and can't be checked", match[2])
+					}
+					images = append(images, match[2])
+				case "check":
+				default:
+					// docker would stop reading directives, later ones can't be told apart
+					return nil, fmt.Errorf("Unknown parser directive '%s' in Dockerfile", key)
+				}
+				continue
+			}
+		}
+
+		// comments are removed, also within continued lines
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// join continued lines
+		if strings.HasSuffix(text, escape) {
+			line += strings.TrimSuffix(text, escape) + " "
+			continue
+		}
+		line += text
+		fields := strings.Fields(line)
+		line = ""
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		// images of COPY --from and RUN --mount=from= are pulled like base images
+		instruction := strings.ToUpper(fields[0])
+		if instruction == "COPY" || instruction == "ADD" || instruction == "RUN" {
+			for _, value := range dockerfile_from_flags(instruction, fields[1:]) {
+				image, err := dockerfile_stage_image(value, stages, count)
+				if err != nil {
+					return nil, err
+				}
+				if image != "" {
+					images = append(images, image)
+				}
+			}
+			continue
+		}
+		if instruction != "FROM" {
+			continue
+		}
+
+		// skip flags like --platform
+		args := []string{}
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "--") {
+				args = append(args, field)
+			}
+		}
+		if len(args) == 0 {
+			return nil, errors.New("FROM without image in Dockerfile")
+		}
+
+		image := args[0]
+		is_stage := stages[strings.ToLower(image)]
+		if len(args) >= 3 && strings.EqualFold(args[1], "AS") {
+			stages[strings.ToLower(args[2])] = true
+		}
+		count++
+		if is_stage || image == "scratch" {
+			continue
+		}
+		if strings.Contains(image, "$") {
+			return nil, fmt.Errorf("Base image '%s' uses build arguments and can't be checked", image)
+		}
+		images = append(images, image)
+	}
+	return images, scanner.Err()
+}
+
+// values of --from of COPY and ADD and of the from option of RUN --mount
+func dockerfile_from_flags(instruction string, fields []string) []string {
+	values := []string{}
+	for _, field := range fields {
+		if !strings.HasPrefix(field, "--") {
+			break
+		}
+		flag := strings.SplitN(field[2:], "=", 2)
+		if len(flag) != 2 {
+			continue
+		}
+		if instruction != "RUN" {
+			if strings.EqualFold(flag[0], "from") {
+				values = append(values, flag[1])
+			}
+			continue
+		}
+		if !strings.EqualFold(flag[0], "mount") {
+			continue
+		}
+		for _, option := range strings.Split(flag[1], ",") {
+			kv := strings.SplitN(option, "=", 2)
+			if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "from") {
+				values = append(values, strings.TrimSpace(kv[1]))
+			}
+		}
+	}
+	return values
+}
+
+// image of a --from value, empty for earlier stages given by name or index
+func dockerfile_stage_image(value string, stages map[string]bool, count int) (string, error) {
+	if value == "" {
+		return "", errors.New("Empty --from in Dockerfile")
+	}
+	if strings.Contains(value, "$") {
+		return "", fmt.Errorf("Image '%s' of --from uses build arguments and can't be checked", value)
+	}
+	if stages[strings.ToLower(value)] {
+		return "", nil
+	}
+	index, err := strconv.Atoi(value)
+	if err == nil {
+		if index < 0 || index >= count {
+			return "", fmt.Errorf("Stage %d of --from is not an earlier stage of the Dockerfile", index)
+		}
+		return "", nil
+	}
+	return value, nil
+}
+
+// check the base images of a Dockerfile against the allowed images
+func check_dockerfile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	images, err := dockerfile_base_images(file)
+	if err != nil {
+		audit_policy("dockerfile", path, err)
+		return err
+	}
+	for _, image := range images {
+		err = check_image_allowed(image)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exclude patterns of the .dockerignore file of a build context
+func dockerignore_patterns(context_dir string) ([]string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(context_dir, ".dockerignore"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return strings.Split(string(b), "\n"), nil
+}
+
+// add a single file of the build context to the hash and the archive
+func context_add_file(h hash.Hash, tw *tar.Writer, path string, rel string, info os.FileInfo) error {
+	fmt.Fprintf(h, "%s\x00%o\x00", rel, info.Mode())
+
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00", target)
+		link = target
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(rel)
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	header.Uid = 0
+	header.Gid = 0
+	header.Uname = ""
+	header.Gname = ""
+	if !info.Mode().IsRegular() {
+		header.Size = 0
+		return tw.WriteHeader(header)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = tw.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(io.MultiWriter(h, tw), file)
+	if err != nil {
+		return err
+	}
+	if n != header.Size {
+		return fmt.Errorf("File %s changed while reading the build context", path)
+	}
+	return nil
+}
+
+// archive of the Dockerfile and every file of the build context sent to docker and its hash
+func context_archive(context_dir string, dockerfile string, w io.Writer) (string, error) {
+	excludes, err := dockerignore_patterns(context_dir)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", dockerfile)
+	tw := tar.NewWriter(w)
+
+	// walk visits files in lexical order, the hash is stable
+	err = filepath.Walk(context_dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(context_dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		// the Dockerfile and .dockerignore are always sent
+		if rel != dockerfile && rel != ".dockerignore" {
+			skip, err := fileutils.Matches(rel, excludes)
+			if err != nil {
+				return err
+			}
+			if skip {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		return context_add_file(h, tw, path, rel, info)
+	})
+	if err != nil {
+		return "", err
+	}
+	err = tw.Close()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hash of the Dockerfile and every file of the build context sent to docker
+func context_hash(context_dir string, dockerfile string) (string, error) {
+	return context_archive(context_dir, dockerfile, ioutil.Discard)
+}
+
+// image name of a build context with its content hash as tag
+func build_image_name(job_name string, hash string) string {
+	name := sanitize_image_name(job_name)
+	if name == "" {
+		name = "unknown"
+	}
+	return fmt.Sprintf("%s/%s:%s", build_image_repository, name, hash[:build_image_hash_length])
+}
+
+// test if an image is named like the images built from workspace Dockerfiles
+func is_build_image(image string) bool {
+	return valid_build_image.MatchString(image)
+}
+
+// check the Dockerfile of the workspace and name its image
+func resolve_dockerfile(pc *ProjektConf, path string) error {
+	if len(*args.image_names) > 0 || *args.compose_file != "" {
+		return errors.New("--dockerfile can't be combined with --image_name or --compose_file")
+	}
+
+	path, err := workspace_file_path(path)
+	if err != nil {
+		return err
+	}
+	config.build_context = filepath.Dir(path)
+	config.dockerfile = filepath.Base(path)
+
+	// the workspace is read with the credentials of the caller
+	var hash string
+	err = as_caller(func() (err error) {
+		err = check_dockerfile(path)
+		if err != nil {
+			return err
+		}
+		hash, err = context_hash(config.build_context, config.dockerfile)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	pc.ImageName = build_image_name(config.job_name, hash)
+	pc.Matrix = nil
+	return nil
+}
+
+// build the image of the workspace Dockerfile, unless an image of the same content exists
+func build_workspace_image(image string, output io.Writer) error {
+	dw, err := docker_wrapper.New()
+	if err != nil {
+		return err
+	}
+	dw.ImageName = image
+
+	_, err = dw.InspectImage()
+	if err == nil {
+		log.Infof("Use cached image %s", image)
+		return nil
+	}
+	if err != docker.ErrNoSuchImage {
+		return err
+	}
+
+	// the archive is created by the wrapper and removed at once, the caller can't change it
+	context, err := ioutil.TempFile("", "jenkins_docker_wrapper_context")
+	if err != nil {
+		return err
+	}
+	defer context.Close()
+	err = os.Remove(context.Name())
+	if err != nil {
+		return err
+	}
+
+	// the context is archived as the caller, docker only gets the archive
+	err = as_caller(func() error {
+		hash, err := context_archive(config.build_context, config.dockerfile, context)
+		if err != nil {
+			return err
+		}
+		if build_image_name(config.job_name, hash) != image {
+			return errors.New("Build context changed while resolving the image")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = context.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Build image %s from %s", image, filepath.Join(config.build_context, config.dockerfile))
+	phase := time.Now()
+	err = dw.BuildImage(image, config.dockerfile, context, output)
+	audit_phase("image_build", phase)
+	if err != nil {
+		return fmt.Errorf("Can't build image: %s", err)
+	}
+	return nil
+}
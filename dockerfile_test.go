@@ -0,0 +1,131 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDockerfileBaseImages(t *testing.T) {
+	images, err := dockerfile_base_images(strings.NewReader(`# build stage
+FROM golang:1.7 AS builder
+RUN go build \
+    ./...
+from --platform=linux/amd64 builder
+FROM scratch
+FROM \
+  debian:jessie
+`))
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, []string{"golang:1.7", "debian:jessie"}, images, "Base images not correct")
+
+	_, err = dockerfile_base_images(strings.NewReader("ARG BASE=debian\nFROM ${BASE}\n"))
+	assert.NotEqual(t, nil, err, "Base image from build argument not rejected")
+}
+
+func TestDockerfileDirectives(t *testing.T) {
+	// with the backtick as escape character the backslash does not continue the line
+	images, err := dockerfile_base_images(strings.NewReader("# escape=`\nFROM debian:jessie\nRUN echo \\\nFROM evil/image\nRUN make `\n  FROM other/image\n"))
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, []string{"debian:jessie", "evil/image"}, images, "Escape directive not honored")
+
+	// directives after a comment are comments
+	images, err = dockerfile_base_images(strings.NewReader("# build\n# escape=`\nFROM debian:jessie\nRUN echo `\nFROM evil/image\n"))
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, []string{"debian:jessie", "evil/image"}, images, "Directive after a comment honored")
+
+	images, err = dockerfile_base_images(strings.NewReader("#syntax = other/frontend:1\nFROM debian:jessie\n"))
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, []string{"other/frontend:1", "debian:jessie"}, images, "Frontend image of the syntax directive missing")
+
+	_, err = dockerfile_base_images(strings.NewReader("# escape=x\nFROM debian:jessie\n"))
+	assert.NotEqual(t, nil, err, "Invalid escape character accepted")
+
+	_, err = dockerfile_base_images(strings.NewReader("# escape=`\n# escape=\\\nFROM debian:jessie\n"))
+	assert.NotEqual(t, nil, err, "Repeated directive accepted")
+
+	_, err = dockerfile_base_images(strings.NewReader("# frontend=other\n# escape=`\nFROM debian:jessie\n"))
+	assert.NotEqual(t, nil, err, "Unknown directive accepted")
+}
+
+func TestDockerfileFromImages(t *testing.T) {
+	images, err := dockerfile_base_images(strings.NewReader(`FROM golang:1.7 AS builder
+FROM debian:jessie
+COPY --from=builder /go/bin/app /usr/bin/app
+COPY --from=0 /go/bin/tool /usr/bin/tool
+COPY --chown=1000 --from=evil/tools:latest /bin/sh /bin/sh
+ADD --from=busybox /bin/busybox /bin/busybox
+RUN --mount=type=cache,target=/root/.cache \
+    --mount=type=bind,from=builder,target=/src \
+    --mount=type=bind,from=alpine:3.4,source=/lib,target=/mnt make
+`))
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, []string{"golang:1.7", "debian:jessie", "evil/tools:latest", "busybox", "alpine:3.4"}, images, "Images of --from not correct")
+
+	_, err = dockerfile_base_images(strings.NewReader("FROM debian:jessie\nCOPY --from=1 /a /a\n"))
+	assert.NotEqual(t, nil, err, "Later stage index not rejected")
+
+	_, err = dockerfile_base_images(strings.NewReader("ARG IMAGE=debian\nFROM debian:jessie\nCOPY --from=${IMAGE} /a /a\n"))
+	assert.NotEqual(t, nil, err, "Image of --from from build argument not rejected")
+
+	_, err = dockerfile_base_images(strings.NewReader("FROM debian:jessie\nRUN --mount=from=$IMAGE,target=/a true\n"))
+	assert.NotEqual(t, nil, err, "Image of --mount from build argument not rejected")
+}
+
+func TestContextHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM debian\nCOPY app /app\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "app"), []byte("v1"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("build.log\n"), 0644)
+
+	hash1, err := context_hash(dir, "Dockerfile")
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	ioutil.WriteFile(filepath.Join(dir, "build.log"), []byte("output"), 0644)
+	hash2, _ := context_hash(dir, "Dockerfile")
+	assert.Equal(t, hash1, hash2, "Ignored file changed the hash")
+
+	ioutil.WriteFile(filepath.Join(dir, "app"), []byte("v2"), 0644)
+	hash3, _ := context_hash(dir, "Dockerfile")
+	assert.NotEqual(t, hash1, hash3, "Changed context did not change the hash")
+
+	name := build_image_name("Kunde1/Job", hash3)
+	assert.Equal(t, build_image_repository+"/kunde1_job:"+hash3[:build_image_hash_length], name, "Image name not correct")
+}
+
+func TestContextArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM debian\n"), 0644)
+	os.Mkdir(filepath.Join(dir, "src"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "src", "app"), []byte("v1"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("x"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("secret\n"), 0644)
+
+	var buf bytes.Buffer
+	hash, err := context_archive(dir, "Dockerfile", &buf)
+	assert.Equal(t, nil, err, "Do not return a error")
+	hash_only, _ := context_hash(dir, "Dockerfile")
+	assert.Equal(t, hash_only, hash, "Archive and hash differ")
+
+	names := []string{}
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, header.Name)
+	}
+	assert.Equal(t, []string{".dockerignore", "Dockerfile", "src/", "src/app"}, names, "Archive content not correct")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"github.com/fsouza/go-dockerclient"
+	"io"
+)
+
+// resolved specification of a build
+type dry_run_spec struct {
+	Name       string             `json:"name,omitempty"`
+	Config     *docker.Config     `json:"config"`
+	HostConfig *docker.HostConfig `json:"host_config"`
+	Command    []string           `json:"command,omitempty"`
+	Services   []dry_run_spec     `json:"services,omitempty"`
+}
+
+// placeholder of the random build network name
+const dry_run_network = "jenkins_docker_wrapper_dry_run"
+
+// generate the specification of a sidecar service container
+func dry_run_service_specification(service ServiceConf) dry_run_spec {
+	dw := docker_wrapper.NewOffline()
+	configure_service_wrapper(dw, service, dry_run_network)
+	copts := dw.CreateOptions()
+	return dry_run_spec{
+		Name:       service.Name,
+		Config:     copts.Config,
+		HostConfig: copts.HostConfig,
+	}
+}
+
+// generate the specification of the build container of an image
+func dry_run_specification(image string) dry_run_spec {
+	dw := docker_wrapper.NewOffline()
+	configure_wrapper(dw, image)
+	spec := dry_run_spec{Command: build_command()}
+	if len(config.services) > 0 {
+		dw.NetworkMode = dry_run_network
+		for _, service := range config.services {
+			spec.Services = append(spec.Services, dry_run_service_specification(service))
+		}
+	}
+	copts := dw.CreateOptions()
+	spec.Name = copts.Name
+	spec.Config = copts.Config
+	spec.HostConfig = copts.HostConfig
+	return spec
+}
+
+// copy of a specification with secrets masked, json escapes secrets containing quotes or <>&
+func mask_specification(spec dry_run_spec) dry_run_spec {
+	if spec.Config != nil {
+		c := *spec.Config
+		c.Env = secrets.mask_list(c.Env)
+		c.Cmd = secrets.mask_list(c.Cmd)
+		c.Entrypoint = secrets.mask_list(c.Entrypoint)
+		if c.Labels != nil {
+			c.Labels = map[string]string{}
+			for key, value := range spec.Config.Labels {
+				c.Labels[key] = secrets.mask(value)
+			}
+		}
+		spec.Config = &c
+	}
+	if spec.HostConfig != nil {
+		h := *spec.HostConfig
+		h.Binds = secrets.mask_list(h.Binds)
+		h.ExtraHosts = secrets.mask_list(h.ExtraHosts)
+		spec.HostConfig = &h
+	}
+	spec.Command = secrets.mask_list(spec.Command)
+
+	services := spec.Services
+	spec.Services = nil
+	for _, service := range services {
+		spec.Services = append(spec.Services, mask_specification(service))
+	}
+	return spec
+}
+
+// print the specification of the build container as json with secrets masked
+func dry_run(w io.Writer) error {
+	specs := []dry_run_spec{}
+	for _, image := range config.images {
+		specs = append(specs, mask_specification(dry_run_specification(image)))
+	}
+
+	// matrix builds print a list of specifications
+	var value interface{} = specs
+	if len(specs) == 1 {
+		value = specs[0]
+	}
+
+	b, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	// secrets without characters escaped by json may also be in other fields
+	_, err = fmt.Fprintln(w, secrets.mask(string(b)))
+	return err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	parse_arguments([]string{"myname", "--dry-run", "-i", "myimage/test1", "--", "myscript"})
+	assert.Equal(t, true, *args.dry_run, "Dry run flag not parsed")
+
+	secrets = secret_masker{}
+	secrets.add("hunter22")
+	config.environment = []string{"DB_PASSWORD=hunter22", "USER=jenkins"}
+	config.volumes = []string{"/jenkins/workspace/kunde1:/jenkins/workspace/kunde1"}
+	config.workspace_path = "/jenkins/workspace/kunde1"
+	config.images = []string{*args.image_name}
+
+	out := new(bytes.Buffer)
+	err := dry_run(out)
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, false, strings.Contains(out.String(), "hunter22"), "Secret not masked")
+
+	var spec dry_run_spec
+	err = json.Unmarshal(out.Bytes(), &spec)
+	assert.Equal(t, nil, err, "Output is not valid JSON")
+	assert.Equal(t, "myimage/test1", spec.Config.Image, "Image name not correct")
+	assert.Equal(t, config.volumes, spec.HostConfig.Binds, "Volumes not correct")
+	assert.Equal(t, "/jenkins/workspace/kunde1", spec.Config.WorkingDir, "Working dir not correct")
+	assert.Equal(t, "myscript", spec.Command[len(spec.Command)-1], "Command not correct")
+}
+
+func TestDryRunEscapedSecret(t *testing.T) {
+	parse_arguments([]string{"myname", "--dry-run", "-i", "myimage/test1", "--", "myscript"})
+	secrets = secret_masker{}
+	secrets.add(`pa&ss"<word>`)
+	config.environment = []string{`DB_PASSWORD=pa&ss"<word>`}
+	config.images = []string{*args.image_name}
+
+	out := new(bytes.Buffer)
+	err := dry_run(out)
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	var spec dry_run_spec
+	err = json.Unmarshal(out.Bytes(), &spec)
+	assert.Equal(t, nil, err, "Output is not valid JSON")
+	assert.Contains(t, spec.Config.Env, "DB_PASSWORD="+secret_mask, "Secret escaped by json not masked")
+	assert.Equal(t, []string{`DB_PASSWORD=pa&ss"<word>`}, config.environment, "Environment of the config modified")
+}
+
+func TestDryRunMatrix(t *testing.T) {
+	parse_arguments([]string{"myname", "--dry-run", "-i", "debian", "-i", "alpine", "--", "myscript"})
+	config.images = *args.image_names
+
+	out := new(bytes.Buffer)
+	err := dry_run(out)
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	var specs []dry_run_spec
+	err = json.Unmarshal(out.Bytes(), &specs)
+	assert.Equal(t, nil, err, "Output is not a JSON list")
+	assert.Equal(t, 2, len(specs), "Not every image printed")
+	assert.Equal(t, "alpine", specs[1].Config.Image, "Image name not correct")
+}
+
+func TestDryRunServices(t *testing.T) {
+	parse_arguments([]string{"myname", "--dry-run", "-i", "debian", "--", "myscript"})
+	config.images = *args.image_names
+	config.services = []ServiceConf{{Name: "db", Image: "postgres", Aliases: []string{"postgres"}}}
+	defer func() { config.services = nil }()
+
+	out := new(bytes.Buffer)
+	err := dry_run(out)
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	var spec dry_run_spec
+	err = json.Unmarshal(out.Bytes(), &spec)
+	assert.Equal(t, nil, err, "Output is not valid JSON")
+	assert.Equal(t, dry_run_network, spec.HostConfig.NetworkMode, "Build container not in the build network")
+	assert.Equal(t, 1, len(spec.Services), "Service not printed")
+	assert.Equal(t, "postgres", spec.Services[0].Config.Image, "Service image not correct")
+	assert.Equal(t, dry_run_network, spec.Services[0].HostConfig.NetworkMode, "Service not in the build network")
+}
+
+func TestDryRunServiceSecret(t *testing.T) {
+	parse_arguments([]string{"myname", "--dry-run", "-i", "debian", "--", "myscript"})
+	config.images = *args.image_names
+	secrets = secret_masker{}
+	secrets.add(`pa&ss"<word>`)
+	config.services = []ServiceConf{{Name: "db", Image: "postgres", Environment: map[string]string{"POSTGRES_PASSWORD": `pa&ss"<word>`}}}
+	defer func() { config.services = nil }()
+
+	out := new(bytes.Buffer)
+	err := dry_run(out)
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	var spec dry_run_spec
+	err = json.Unmarshal(out.Bytes(), &spec)
+	assert.Equal(t, nil, err, "Output is not valid JSON")
+	assert.Contains(t, spec.Services[0].Config.Env, "POSTGRES_PASSWORD="+secret_mask, "Secret of the service not masked")
+}
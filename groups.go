@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"github.com/danryan/go-group/os/group"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// groups granting privileges on the host, never passed into a container
+var forbidden_groups = []string{"docker", "sudo", "wheel", "admin", "adm", "root"}
+
+// group of the host
+type host_group struct {
+	name string
+	gid  int
+}
+
+// supplementary groups of a host user, without the primary group
+func lookup_supplementary_groups(user_struct *user.User, primary_gid string) ([]host_group, error) {
+	gids, err := user_struct.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	groups := []host_group{}
+	for _, gid_str := range gids {
+		if gid_str == primary_gid {
+			continue
+		}
+		gid, err := strconv.Atoi(gid_str)
+		if err != nil {
+			return nil, err
+		}
+		group_struct, err := group.LookupGroupId(gid_str)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, host_group{name: group_struct.Name, gid: gid})
+	}
+	return groups, nil
+}
+
+// test if a group is allowed to be passed into the container
+func check_group_allowed(allowed []string, g host_group) error {
+	if g.gid == 0 || string_in_slice(g.name, forbidden_groups) {
+		return fmt.Errorf("Group '%s' is never passed into a container", g.name)
+	}
+	for _, pattern := range allowed {
+		if matched, err := filepath.Match(pattern, g.name); err == nil && matched {
+			return nil
+		}
+	}
+	return fmt.Errorf("Group '%s' is not allowed by the config file", g.name)
+}
+
+// groups allowed by the config file
+func filter_groups(allowed []string, groups []host_group) []host_group {
+	filtered := []host_group{}
+	for _, g := range groups {
+		err := check_group_allowed(allowed, g)
+		audit_policy("group", g.name, err)
+		if err == nil {
+			filtered = append(filtered, g)
+		}
+	}
+	return filtered
+}
+
+// gids of the groups for docker
+func group_ids(groups []host_group) []string {
+	gids := []string{}
+	for _, g := range groups {
+		gids = append(gids, strconv.Itoa(g.gid))
+	}
+	return gids
+}
+
+// names of the groups
+func group_names(groups []host_group) []string {
+	names := []string{}
+	for _, g := range groups {
+		names = append(names, g.name)
+	}
+	return names
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFilterGroups(t *testing.T) {
+	groups := []host_group{
+		{name: "cache", gid: 2000},
+		{name: "docker", gid: 999},
+		{name: "sudo", gid: 27},
+		{name: "shared-m2", gid: 2001},
+		{name: "audio", gid: 29},
+	}
+
+	filtered := filter_groups([]string{"cache", "shared-*", "docker"}, groups)
+	assert.Equal(t, []host_group{{name: "cache", gid: 2000}, {name: "shared-m2", gid: 2001}}, filtered, "Groups not filtered")
+	assert.Equal(t, []string{"2000", "2001"}, group_ids(filtered), "Group ids not correct")
+
+	assert.NotEqual(t, nil, check_group_allowed([]string{"*"}, host_group{name: "docker", gid: 999}), "Docker group allowed")
+	assert.NotEqual(t, nil, check_group_allowed([]string{"*"}, host_group{name: "other", gid: 0}), "Gid 0 allowed")
+	assert.Equal(t, 0, len(filter_groups(nil, groups)), "Groups allowed without allowlist")
+}
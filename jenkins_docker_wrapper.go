@@ -5,48 +5,148 @@ import (
 	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"github.com/danryan/go-group/os/group"
-	"github.com/former03/docker_wrapper"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
 	"gopkg.in/alecthomas/kingpin.v1"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/user"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 type Arguments struct {
-	debug        *bool   // Debug mode flag
-	projekt_conf *bool   // Detect image_name from projekt_conf
-	image_name   *string // Image name of docker image
-	no_rm        *bool   // Don't remove container after execution
+	debug        *bool     // Debug mode flag
+	projekt_conf *bool     // Detect image_name from projekt_conf
+	image_name   *string   // Image name of docker image
+	image_names  *[]string // Image names of a matrix build
+	parallel     *int      // Number of matrix builds running in parallel
+	no_rm        *bool     // Don't remove container after execution
+	stats_file   *string   // Write resource usage as json to this file in the workspace
+	secrets_file *string   // File with secret values to mask, one per line
+	mask_output  *bool     // Mask secrets in the build output
+	log_level    *string   // Log level of the wrapper
+	log_format   *string   // Log format of the wrapper
+	log_file     *string   // Log file of the wrapper
+
+	keep_on_failure   *bool // Keep the container of a failed build
+	commit_on_failure *bool // Commit the container of a failed build to a debug image
+
+	dry_run *bool // Print the container specification without running it
+
+	compose_file    *string // Compose file in the workspace
+	compose_service *string // Compose service to run the build in
+
+	dockerfile *string // Dockerfile in the workspace to build the image from
+
+	ssh_agent_keys *[]string // Fingerprints of the ssh keys usable in the build
+
+	secret_files *[]string // Host files delivered into the container, host_path:container_path
+
+	attach_job_name *string // Job name of the container to attach to
+	attach_build_id *int    // Build id of the container to attach to
 }
 
 type ConfigFile struct {
 	JenkinsUser  string `json:"jenkins_user"`
 	JenkinsHome  string `json:"jenkins_home"`
 	DefaultShell string `json:"default_shell"`
+	AuditLog     string `json:"audit_log"`
+	AuditSyslog  bool   `json:"audit_syslog"`
+
+	SecretPatterns  []string `json:"secret_patterns"`
+	MaskBuildOutput bool     `json:"mask_build_output"`
+
+	LogLevel  string `json:"log_level"`
+	LogFormat string `json:"log_format"`
+	LogFile   string `json:"log_file"`
+
+	KeepRetention string `json:"keep_retention"`
+
+	MaxParallel int `json:"max_parallel"`
+
+	AllowedImages []string `json:"allowed_images"`
+
+	AllowedGroups []string `json:"allowed_groups"`
+
+	AllowedUsers      []string `json:"allowed_users"`
+	AllowedUserGroups []string `json:"allowed_user_groups"`
+
+	SshAgentKeys []string `json:"ssh_agent_keys"`
+
+	SshKnownHosts            string `json:"ssh_known_hosts"`
+	SshStrictHostKeyChecking bool   `json:"ssh_strict_host_key_checking"`
+
+	CaCertificates []string `json:"ca_certificates"`
+
+	HttpProxy  string   `json:"http_proxy"`
+	HttpsProxy string   `json:"https_proxy"`
+	NoProxy    []string `json:"no_proxy"`
+	Dns        []string `json:"dns"`
+	DnsSearch  []string `json:"dns_search"`
+	ExtraHosts []string `json:"extra_hosts"`
+
+	AllowedJobDns        []string `json:"allowed_job_dns"`
+	AllowedJobDnsSearch  []string `json:"allowed_job_dns_search"`
+	AllowedJobExtraHosts []string `json:"allowed_job_extra_hosts"`
+	AllowedJobNoProxy    []string `json:"allowed_job_no_proxy"`
 }
 
-// TODO Rename to standard case 
+// TODO Rename to standard case
 type Config struct {
-	my_args            []string // Arguments for me
-	container_args     []string // Arguments for the container shell
-	tmp_files_to_move  []string // Files to copy to the container
-	basename           string   // Base name of executable
-	environment        []string // Environment variables for the container
-	volumes            []string // Secure location to mount
-	default_shell      string
-	job_name           string
-	build_id           int
-	jenkins_user       string
-	jenkins_home       string
-	workspace_path     string
-	tmp_dir            string                          // Container tmp dir
-	cleanup_containers []string                        // Containers to remove at the end
-	wrappers           *[]docker_wrapper.DockerWrapper // Docker wrappers
+	command             string   // Subcommand to run, empty for a build
+	my_args             []string // Arguments for me
+	container_args      []string // Arguments for the container shell
+	tmp_files_to_move   []string // Files to copy to the container
+	basename            string   // Base name of executable
+	environment         []string // Environment variables for the container
+	volumes             []string // Secure location to mount
+	default_shell       string
+	job_name            string
+	build_id            int
+	jenkins_user        string
+	jenkins_home        string
+	container_home      string       // Home of the jenkins user in the container
+	jenkins_uid         int          // Uid of the jenkins user
+	jenkins_gid         int          // Gid of the jenkins user
+	jenkins_group       string       // Primary group of the jenkins user
+	jenkins_groups      []host_group // Supplementary groups of the jenkins user passed into the container
+	allowed_groups      []string     // Supplementary groups allowed to be passed into the container
+	allowed_users       []string     // Users allowed to run builds
+	allowed_user_groups []string     // Members of these groups are allowed to run builds
+	caller              *caller      // Authenticated calling user
+	user_provisioning   string       // Strategy to provision the jenkins user
+	workspace_path      string
+	workspace_source    string           // Workspace with resolved symlinks, mounted at workspace_path
+	workspace_tmp       string           // Resolved @tmp directory of a pipeline workspace
+	mount_checks        []mount_check    // Workspace mounts verified in the started container
+	audit_log           string           // Path of the audit log
+	audit_syslog        bool             // Send audit records to syslog
+	secret_patterns     []string         // Env names with secret values
+	mask_build_output   bool             // Mask secrets in the build output
+	keep_retention      time.Duration    // Retention of kept containers and debug images
+	images              []string         // Images to run the build in
+	parallel            int              // Number of matrix builds running in parallel
+	max_parallel        int              // Upper limit of parallel matrix builds
+	allowed_images      []*regexp.Regexp // Images allowed to run, empty allows all
+	services            []ServiceConf    // Sidecar services of every build
+	dockerfile          string           // Dockerfile to build the image from, relative to the build context
+	build_context       string           // Directory of the Dockerfile
+	tmp_dir             string           // Container tmp dir
+	cleanup_containers  []string         // Containers to remove at the end
+	ssh_auth_sock       string           // Agent socket of the caller
+	ssh_agent_keys      []string         // Fingerprints of the ssh keys usable in builds, empty allows all
+	ssh_agent           *ssh_agent_proxy // Agent socket of the build
+	ssh_known_hosts     string           // Known hosts file of all builds
+	known_hosts         []byte           // Known hosts injected into the build
+	secret_files        []secret_file    // Secret files delivered into the tmpfs of the build
+	ca_certificates     []byte           // CA certificates injected into the build
+	network             network_settings // Proxy, dns and hosts of the build
+	network_policy      network_policy   // Proxy, dns and hosts settings a job may override
+	ssh_strict_hosts    bool             // Inject a ssh_config enforcing StrictHostKeyChecking
 }
 
 var version = "0.0.1"
@@ -57,6 +157,8 @@ var args Arguments
 
 // ensure cleanup of all ressources
 func cleanup() {
+	run_teardowns()
+	config.ssh_agent.close()
 }
 
 func parse_config_file_io(r io.Reader) (cf *ConfigFile, err error) {
@@ -91,6 +193,16 @@ func parse_arguments(in_args []string) {
 	// get basename of me
 	config.basename = filepath.Base(in_args[0])
 
+	// handle subcommands
+	if len(in_args) > 1 && in_args[1] == command_attach && !parse_arguments_legacy(config.basename) {
+		config.command = command_attach
+		config.my_args = in_args[2:]
+		config.container_args = []string{}
+		args = parse_attach_arguments(config.basename, config.my_args)
+		return
+	}
+	config.command = ""
+
 	// split arguments
 	config.my_args, config.container_args = split_arguments(config.basename, in_args[1:])
 
@@ -144,6 +256,14 @@ func split_arguments(basename string, cli_args []string) (my []string, container
 	return my, container
 }
 
+// flags shared by all subcommands
+func parse_common_flags(parser *kingpin.Application, args *Arguments) {
+	args.debug = parser.Flag("debug", "Enable debug mode.").Short('d').Bool()
+	args.log_level = parser.Flag("log_level", "Log level (debug, info, warning, error).").String()
+	args.log_format = parser.Flag("log_format", "Log format (text, json).").String()
+	args.log_file = parser.Flag("log_file", "Write the wrapper log to this file.").String()
+}
+
 // parse and validate my command line arguments
 func parse_my_arguments(basename string, cli_args []string) Arguments {
 
@@ -151,19 +271,44 @@ func parse_my_arguments(basename string, cli_args []string) Arguments {
 
 	parser := kingpin.New(basename, "")
 
-	args.debug = parser.Flag("debug", "Enable debug mode.").Short('d').Bool()
+	parse_common_flags(parser, &args)
 	args.projekt_conf = parser.Flag("projekt_conf", "Parse projekt.conf for image name.").Short('p').Bool()
-	args.image_name = parser.Flag("image_name", "Image name of docker image.").Short('i').String()
+	args.image_names = parser.Flag("image_name", "Image name of docker image, repeat for a matrix build.").Short('i').Strings()
+	args.parallel = parser.Flag("parallel", "Number of matrix builds running in parallel.").Int()
 	args.no_rm = parser.Flag("no_rm", "Don't remove container after execution.").Short('n').Bool()
+	args.stats_file = parser.Flag("stats_file", "Write resource usage as JSON to this file in the workspace.").String()
+	args.secrets_file = parser.Flag("secrets_file", "File with secret values to mask, one per line.").String()
+	args.mask_output = parser.Flag("mask_output", "Mask secrets in the build output.").Bool()
+	args.keep_on_failure = parser.Flag("keep-on-failure", "Keep the stopped container of a failed build.").Bool()
+	args.commit_on_failure = parser.Flag("commit-on-failure", "Commit the container of a failed build to a debug image.").Bool()
+	args.dry_run = parser.Flag("dry-run", "Print the resolved container specification as JSON and exit.").Bool()
+	args.compose_file = parser.Flag("compose_file", "Read image and sidecar services from this compose file in the workspace.").String()
+	args.compose_service = parser.Flag("compose_service", "Service of the compose file to run the build in.").String()
+	args.dockerfile = parser.Flag("dockerfile", "Build the image from this Dockerfile in the workspace.").String()
+	args.secret_files = parser.Flag("secret-file", "Deliver a host file into a tmpfs of the container, host_path:container_path, repeatable.").Strings()
+	args.ssh_agent_keys = parser.Flag("ssh_agent_key", "Fingerprint of a ssh agent key usable in the build, repeat for more keys.").Strings()
 
-	if parse_arguments_legacy(basename) {
-		args.image_name = &cli_args[0]
+	legacy := parse_arguments_legacy(basename)
+	legacy_image_name := ""
+	if legacy {
+		legacy_image_name = cli_args[0]
 		cli_args = []string{}
 	}
 
 	parser.Version(version)
 	parser.Parse(cli_args)
 
+	if legacy {
+		*args.image_names = []string{legacy_image_name}
+	}
+
+	// first image name
+	image_name := ""
+	if len(*args.image_names) > 0 {
+		image_name = (*args.image_names)[0]
+	}
+	args.image_name = &image_name
+
 	if *args.debug {
 		log.SetLevel(log.DebugLevel)
 	}
@@ -172,36 +317,36 @@ func parse_my_arguments(basename string, cli_args []string) Arguments {
 }
 
 func build_environment_blacklist(key string, value string) (additional []string, err error) {
+	audit_policy("env_blacklist", key, errors.New("blacklisted"))
 	return []string{}, nil
 }
 
-func build_environment_validate_user(key string, value string) (additional []string, err error) {
-	if value == config.jenkins_user {
-		return []string{fmt.Sprintf("%s=%s", key, value)}, err
+// the user is identified by the real uid, the environment is informational only
+func build_environment_user(key string, value string) (additional []string, err error) {
+	if value != config.jenkins_user {
+		log.Debugf("Ignore user environment '%s', running as '%s'", value, config.jenkins_user)
 	}
-	err = errors.New(fmt.Sprintf("Invalid user environment '%s', expect to be '%s'", value, config.jenkins_user))
-	return []string{}, err
+	return []string{fmt.Sprintf("%s=%s", key, config.jenkins_user)}, nil
 }
 
 func build_environment_validate_workspace(key string, value string) (additional []string, err error) {
 
 	if !filepath.IsAbs(value) {
 		err := errors.New(fmt.Sprintf("Invalid path in %s '%s', expected to be absolute path", key, value))
+		audit_policy("workspace", value, err)
 		return []string{}, err
 	}
 
-	path, err := filepath.Abs(value)
+	// the workspace root itself is never mounted
+	path, err := check_workspace_path(value)
 	if err != nil {
-		return []string{}, err
-	}
-
-	workspace_path := filepath.Join(config.jenkins_home, "workspace")
-	if !strings.HasPrefix(path, workspace_path) {
-		err := errors.New(fmt.Sprintf("Invalid path in %s '%s', expected to be within %s", key, path, workspace_path))
+		err := fmt.Errorf("Invalid path in %s: %s", key, err)
+		audit_policy("workspace", value, err)
 		return []string{}, err
 	}
 
 	config.workspace_path = path
+	audit_policy("workspace", path, nil)
 
 	return []string{fmt.Sprintf("%s=%s", key, path)}, err
 }
@@ -210,13 +355,15 @@ func build_environment_validate_ssh_auth_sock(key string, value string) (additio
 
 	if !filepath.IsAbs(value) {
 		err := errors.New(fmt.Sprintf("Invalid path in %s '%s', expected to be absolute path", key, value))
+		audit_policy("ssh_auth_sock", value, err)
 		return []string{}, err
 	}
+	audit_policy("ssh_auth_sock", value, nil)
 
-	// append ssh socket to copy slice
-	config.tmp_files_to_move = append(config.tmp_files_to_move, value)
+	// the agent is forwarded through a socket of the build, the original stays in place
+	config.ssh_auth_sock = value
 
-	return []string{fmt.Sprintf("%s=%s", key, value)}, err
+	return []string{fmt.Sprintf("%s=%s", key, ssh_agent_socket_path("/tmp"))}, err
 }
 
 func build_environment_store_build_id(key string, value string) (additional []string, err error) {
@@ -233,10 +380,8 @@ func build_environment_store_job_name(key string, value string) (additional []st
 	return []string{fmt.Sprintf("%s=%s", key, value)}, err
 }
 
-// filter and check environment
-func build_environment(env []string) (output []string, err error) {
-
-	output = []string{}
+// handlers of environment keys controlled by the wrapper
+func build_environment_handlers() map[string]func(string, string) ([]string, error) {
 
 	// create handler map
 	var m map[string]func(string, string) ([]string, error)
@@ -250,9 +395,10 @@ func build_environment(env []string) (output []string, err error) {
 	m["NVM_DIR"] = build_environment_blacklist
 	m["NVM_NODEJS_ORG_MIRROR"] = build_environment_blacklist
 	m["LANG"] = build_environment_blacklist
+	m["HOME"] = build_environment_blacklist
 
 	// validations
-	m["USER"] = build_environment_validate_user
+	m["USER"] = build_environment_user
 	m["WORKSPACE"] = build_environment_validate_workspace
 
 	// validate and move into container
@@ -264,6 +410,16 @@ func build_environment(env []string) (output []string, err error) {
 	// store project name
 	m["JOB_NAME"] = build_environment_store_job_name
 
+	return m
+}
+
+// filter and check environment
+func build_environment(env []string) (output []string, err error) {
+
+	output = []string{}
+
+	m := build_environment_handlers()
+
 	for _, env_elem := range env {
 
 		// split environment
@@ -294,6 +450,21 @@ func build_environment(env []string) (output []string, err error) {
 	return output, err
 }
 
+// replace variables of the environment by the given ones
+func override_environment(env []string, overrides []string) []string {
+	keys := map[string]bool{}
+	for _, o := range overrides {
+		keys[strings.SplitN(o, "=", 2)[0]] = true
+	}
+	output := []string{}
+	for _, e := range env {
+		if !keys[strings.SplitN(e, "=", 2)[0]] {
+			output = append(output, e)
+		}
+	}
+	return append(output, overrides...)
+}
+
 // parse and validate local config
 func parse_config() {
 
@@ -308,94 +479,212 @@ func create_tmp_dir() error {
 	config.tmp_dir = temp_dir
 	log.Debugf("Created container temp dir in %s", temp_dir)
 
-	err = os.Chmod(temp_dir, 0777)
+	// only the jenkins user may enter the tmp dir of its build
+	err = os.Chown(temp_dir, config.jenkins_uid, config.jenkins_gid)
 	if err != nil {
 		return err
 	}
 
-	for i := range config.tmp_files_to_move {
-		src := config.tmp_files_to_move[i]
-		dest := filepath.Join(temp_dir, filepath.Base(src))
-		log.Debugf("Moving %s to %s", src, dest)
-		err := os.Rename(src, dest)
-		if err != nil {
-			return err
+	// the caller has to be allowed to move the files
+	return as_caller(func() error {
+		for i := range config.tmp_files_to_move {
+			src := config.tmp_files_to_move[i]
+			dest := filepath.Join(temp_dir, filepath.Base(src))
+			log.Debugf("Moving %s to %s", src, dest)
+			err := os.Rename(src, dest)
+			if err != nil {
+				return err
+			}
 		}
-	}
+		return nil
+	})
+}
 
-	return nil
+// write a value as json file, call as the caller so the file is owned by it,
+// a symlink planted at path is not followed
+func write_json_file(path string, value interface{}) error {
+	b, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(b, '\n'))
+	close_err := file.Close()
+	if err != nil {
+		return err
+	}
+	return close_err
 }
 
-func copy_file(src, dst string) (int64, error) {
-	src_file, err := os.Open(src)
+// set default config
+// parse arguments and config file
+func initialize_config() error {
+
+	// default logging until the config is parsed
+	err := configure_logging(default_log_level, default_log_format, "")
 	if err != nil {
-		return 0, err
+		return err
 	}
-	defer src_file.Close()
 
-	src_file_stat, err := src_file.Stat()
+	parse_arguments(os.Args)
+
+	// parse config file
+	config_file, err := parse_config_file("/etc/jenkins_docker_wrapper.conf")
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	if !src_file_stat.Mode().IsRegular() {
-		return 0, fmt.Errorf("%s is not a regular file", src)
+	// set up logging from arguments and config file
+	log_level := first_non_empty(*args.log_level, config_file.LogLevel, default_log_level)
+	if *args.debug {
+		log_level = "debug"
 	}
+	log_format := first_non_empty(*args.log_format, config_file.LogFormat, default_log_format)
+	err = configure_logging(log_level, log_format, config_file.LogFile)
+	if err != nil {
+		return err
+	}
+
+	// overwrite default config from config file
+	if config_file.DefaultShell != "" {
+		config.default_shell = config_file.DefaultShell
+	} else {
+		config.default_shell = "/bin/bash"
+	}
+	log.Debugf("Set DefaultShell to '%s'", config.default_shell)
+
+	if config_file.JenkinsUser != "" {
+		config.jenkins_user = config_file.JenkinsUser
+	} else {
+		config.jenkins_user = "jenkins"
+	}
+	log.Debugf("Set JenkinsUser to '%s'", config.jenkins_user)
+
+	if config_file.JenkinsHome != "" {
+		config.jenkins_home = config_file.JenkinsHome
+	} else {
+		config.jenkins_home = "/jenkins"
+	}
+	log.Debugf("Set JenkinsHome to '%s'", config.jenkins_home)
 
-	dst_file, err := os.Create(dst)
+	config.audit_log = config_file.AuditLog
+	config.audit_syslog = config_file.AuditSyslog
+
+	if len(config_file.SecretPatterns) > 0 {
+		config.secret_patterns = config_file.SecretPatterns
+	} else {
+		config.secret_patterns = default_secret_patterns
+	}
+	log.Debugf("Set SecretPatterns to '%s'", config.secret_patterns)
+	config.mask_build_output = config_file.MaskBuildOutput
+
+	keep_retention := first_non_empty(config_file.KeepRetention, default_keep_retention)
+	config.keep_retention, err = time.ParseDuration(keep_retention)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	defer dst_file.Close()
-	return io.Copy(dst_file, src_file)
-}
+	log.Debugf("Set KeepRetention to '%s'", config.keep_retention)
 
-func copy_ssh_known_hosts() error {
-	source := filepath.Join(os.Getenv("HOME"), ".ssh/known_hosts")
-	dest := filepath.Join(config.tmp_dir, "known_hosts")
-	log.Debugf("Copy ssh known hosts from '%s' to '%s'", source, dest)
-	_, err := copy_file(source, dest)
-	return err
-}
+	if config_file.MaxParallel > 0 {
+		config.max_parallel = config_file.MaxParallel
+	} else {
+		config.max_parallel = default_max_parallel
+	}
+	log.Debugf("Set MaxParallel to '%d'", config.max_parallel)
 
-// set default config
-func initialize() error {
+	config.allowed_images, err = compile_image_patterns(config_file.AllowedImages)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Set AllowedImages to '%s'", config_file.AllowedImages)
+
+	config.allowed_groups = config_file.AllowedGroups
+	log.Debugf("Set AllowedGroups to '%s'", config.allowed_groups)
 
-	// set log level
-	log.SetLevel(log.DebugLevel)
-
-    // parse config file
-    config_file, err := parse_config_file("/etc/jenkins_docker_wrapper.conf")
-    if err != nil {
-        return err
-    }
-
-    // overwrite default config from config file
-    if config_file.DefaultShell != "" {
-	    config.default_shell = config_file.DefaultShell
-    } else {
-	    config.default_shell = "/bin/bash"
-    }
-    log.Debugf("Set DefaultShell to '%s'", config.default_shell)
-
-    if config_file.JenkinsUser != "" {
-        config.jenkins_user = config_file.JenkinsUser
-    } else {
-        config.jenkins_user = "jenkins"
-    }
-    log.Debugf("Set JenkinsUser to '%s'", config.jenkins_user)
-
-    if config_file.JenkinsHome != "" {
-        config.jenkins_home = config_file.JenkinsHome
-    } else {
-        config.jenkins_home = "/jenkins"
-    }
-    log.Debugf("Set JenkinsHome to '%s'", config.jenkins_home)
+	// only the jenkins user is allowed by default
+	config.allowed_users = config_file.AllowedUsers
+	config.allowed_user_groups = config_file.AllowedUserGroups
+	if len(config.allowed_users) == 0 && len(config.allowed_user_groups) == 0 {
+		config.allowed_users = []string{config.jenkins_user}
+	}
+	log.Debugf("Set AllowedUsers to '%s' and AllowedUserGroups to '%s'", config.allowed_users, config.allowed_user_groups)
+
+	config.ssh_agent_keys = config_file.SshAgentKeys
+	log.Debugf("Set SshAgentKeys to '%s'", config.ssh_agent_keys)
+
+	config.ssh_known_hosts = first_non_empty(config_file.SshKnownHosts, default_ssh_known_hosts)
+	config.ssh_strict_hosts = config_file.SshStrictHostKeyChecking
+	log.Debugf("Set SshKnownHosts to '%s'", config.ssh_known_hosts)
+
+	config.ca_certificates, err = read_ca_certificates(config_file.CaCertificates)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Set CaCertificates to '%s'", config_file.CaCertificates)
+
+	config.network = network_settings{
+		http_proxy:  config_file.HttpProxy,
+		https_proxy: config_file.HttpsProxy,
+		no_proxy:    config_file.NoProxy,
+		dns:         config_file.Dns,
+		dns_search:  config_file.DnsSearch,
+		extra_hosts: config_file.ExtraHosts,
+	}
+	err = check_network_settings(&config.network)
+	if err != nil {
+		return err
+	}
+	config.network_policy = network_policy{
+		dns:         config_file.AllowedJobDns,
+		dns_search:  config_file.AllowedJobDnsSearch,
+		extra_hosts: config_file.AllowedJobExtraHosts,
+		no_proxy:    config_file.AllowedJobNoProxy,
+	}
 
+	// identify the caller by the real uid
+	err = authenticate_caller()
+	if err != nil {
+		return err
+	}
+
+	// the log file of the caller is opened with its credentials
+	if *args.log_file != "" {
+		err = check_log_file_path(*args.log_file)
+		if err != nil {
+			return err
+		}
+		err = as_caller(func() error {
+			return configure_logging(log_level, log_format, *args.log_file)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// set up the build environment
+func initialize() error {
 
 	config.cleanup_containers = []string{}
 
-	parse_arguments(os.Args)
+	// collect secrets before the environment gets logged
+	collect_secrets_env(config.secret_patterns, os.Environ())
+	if *args.secrets_file != "" {
+		err := as_caller(func() error {
+			return collect_secrets_file(*args.secrets_file)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if *args.mask_output {
+		config.mask_build_output = true
+	}
 
 	// evaluate environment
 	env, err := build_environment(os.Environ())
@@ -406,6 +695,117 @@ func initialize() error {
 	// add utf8 language env
 	env = append(env, "LANG=C.UTF-8")
 
+	err = resolve_jenkins_user()
+	if err != nil {
+		return err
+	}
+
+	// the workspace is mounted from its resolved path and checked again in the started container
+	var workspace_info os.FileInfo
+	config.workspace_source, workspace_info, err = resolve_workspace(config.workspace_path)
+	if err != nil {
+		return err
+	}
+	config.mount_checks = []mount_check{{target: config.workspace_path, info: workspace_info}}
+	var workspace_tmp_info os.FileInfo
+	config.workspace_tmp, workspace_tmp_info, err = resolve_workspace_tmp(config.workspace_path)
+	if err != nil {
+		return err
+	}
+	if config.workspace_tmp != "" {
+		config.mount_checks = append(config.mount_checks, mount_check{
+			target: workspace_sibling(config.workspace_path, workspace_tmp_suffix),
+			info:   workspace_tmp_info,
+		})
+	}
+
+	// secret files are kept in memory, never written to the host
+	config.secret_files, err = resolve_secret_files(*args.secret_files)
+	if err != nil {
+		return err
+	}
+
+	// resolve the images to run in
+	projekt_conf := &ProjektConf{}
+	if *args.projekt_conf {
+		err = as_caller(func() (err error) {
+			projekt_conf, err = parse_projekt_conf(filepath.Join(config.workspace_path, projekt_conf_name))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if *args.compose_file != "" {
+		compose_env, err := apply_compose_file(projekt_conf, *args.compose_file, *args.compose_service)
+		if err != nil {
+			return err
+		}
+		env = append(env, compose_env...)
+	}
+	dockerfile := first_non_empty(*args.dockerfile, projekt_conf.Dockerfile)
+	if dockerfile != "" {
+		err = resolve_dockerfile(projekt_conf, dockerfile)
+		if err != nil {
+			return err
+		}
+	}
+	config.images, err = resolve_images(*args.image_names, projekt_conf)
+	if err != nil {
+		return err
+	}
+	// images built from the workspace are checked by their base images
+	if config.dockerfile == "" {
+		for _, image := range config.images {
+			err = check_image_allowed(image)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	config.user_provisioning, err = resolve_provisioning(projekt_conf.UserProvisioning)
+	if err != nil {
+		return err
+	}
+
+	// home and shell of the jenkins user in the container
+	config.container_home, err = resolve_container_home(first_non_empty(projekt_conf.JenkinsHome, config.jenkins_home))
+	if err != nil {
+		return err
+	}
+	config.default_shell, err = resolve_shell(first_non_empty(projekt_conf.DefaultShell, config.default_shell))
+	if err != nil {
+		return err
+	}
+	env = append(env, fmt.Sprintf("HOME=%s", config.container_home))
+	log.Debugf("Set container home to '%s' and shell to '%s'", config.container_home, config.default_shell)
+	config.parallel = resolve_parallel(*args.parallel, projekt_conf.Parallel, config.max_parallel)
+
+	// proxy, dns and hosts of the config file and the job
+	config.network, err = resolve_network_settings(config.network, config.network_policy, projekt_conf)
+	if err != nil {
+		return err
+	}
+	env = override_environment(env, config.network.proxy_environment())
+
+	// tools without a system trust store use the injected certificates
+	if len(config.ca_certificates) > 0 {
+		env = override_environment(env, ca_environment())
+	}
+
+	// known hosts of the config file and the job
+	config.known_hosts, err = assemble_known_hosts(config.ssh_known_hosts, projekt_conf.KnownHosts)
+	if err != nil {
+		return err
+	}
+
+	// sidecar services have to pass the same checks
+	config.services, err = resolve_services(projekt_conf.Services)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Run in images %s with parallelism %d", config.images, config.parallel)
+
 	config.environment = env
 	for i := range env {
 		log.Debugf("container env var: %s", env[i])
@@ -416,16 +816,35 @@ func initialize() error {
 		config.volumes,
 		fmt.Sprintf(
 			"%s:%s",
-			config.workspace_path,
+			config.workspace_source,
 			config.workspace_path,
 		),
 	)
 
-	// append script to the files to copy
-	if n := len(config.container_args); n > 0 {
+	// pipeline sh steps run scripts from the @tmp directory
+	if config.workspace_tmp != "" {
+		config.volumes = append(
+			config.volumes,
+			fmt.Sprintf(
+				"%s:%s",
+				config.workspace_tmp,
+				workspace_sibling(config.workspace_path, workspace_tmp_suffix),
+			),
+		)
+	}
+
+	// append script to the files to copy, scripts of the mounted @tmp directory stay in place
+	if n := len(config.container_args); n > 0 && !in_workspace_tmp(config.container_args[n-1]) {
 		config.tmp_files_to_move = append(config.tmp_files_to_move, config.container_args[n-1])
 	}
 
+	// a dry run must not touch any files
+	if *args.dry_run {
+		config.tmp_dir = filepath.Join(os.TempDir(), "jenkins_docker_wrapper_dry_run")
+		config.volumes = append(config.volumes, fmt.Sprintf("%s:/tmp", config.tmp_dir))
+		return nil
+	}
+
 	// create containers temp dir
 	err = create_tmp_dir()
 	if err != nil {
@@ -441,10 +860,16 @@ func initialize() error {
 		),
 	)
 
-	// move ssh known hosts into container
-	err = copy_ssh_known_hosts()
-	if err != nil {
-		return err
+	// forward the ssh agent of the caller
+	if config.ssh_auth_sock != "" {
+		keys, err := resolve_ssh_agent_keys(config.ssh_agent_keys, *args.ssh_agent_keys)
+		if err != nil {
+			return err
+		}
+		config.ssh_agent, err = start_ssh_agent_proxy(config.ssh_auth_sock, keys, config.tmp_dir, config.jenkins_uid, config.jenkins_gid)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -468,161 +893,302 @@ func run_command_expect(dw *docker_wrapper.DockerWrapper, command []string, expe
 	return stdout, stderr, ret_val, err
 }
 
-func init_container(dw *docker_wrapper.DockerWrapper) error {
-	jenkins_home_path := "/jenkins"
-	ssh_dir_path := filepath.Join(jenkins_home_path, ".ssh")
-	ssh_known_hosts_path := filepath.Join(ssh_dir_path, "known_hosts")
+// set up the wrapper for the build container
+func configure_wrapper(dw *docker_wrapper.DockerWrapper, image string) {
+	dw.ImageName = image
+	dw.Volumes = config.volumes
+	dw.Environment = config.environment
+	dw.WorkingDir = config.workspace_path
+	dw.Labels = container_labels(os.Getuid(), image)
+
+	// run as the jenkins user, setuid binaries like sudo can't gain privileges
+	dw.User = fmt.Sprintf("%d:%d", config.jenkins_uid, config.jenkins_gid)
+	dw.SecurityOpt = []string{"no-new-privileges"}
+	dw.GroupAdd = group_ids(config.jenkins_groups)
+	if len(config.secret_files) > 0 {
+		dw.Tmpfs = secret_files_tmpfs(config.jenkins_uid, config.jenkins_gid)
+	}
+	dw.DNS = config.network.dns
+	dw.DNSSearch = config.network.dns_search
+	dw.ExtraHosts = config.network.extra_hosts
+}
 
-	username := config.jenkins_user
-	user_struct, err := user.Lookup(username)
-	if err != nil {
-		return err
+// command running the jenkins script in the container
+func build_command() []string {
+	command := []string{config.default_shell}
+	return append(command, config.container_args...)
+}
+
+// name of a phase in the audit log, prefixed by the image in matrix builds
+func build_phase_name(image string, name string) string {
+	if len(config.images) > 1 {
+		return fmt.Sprintf("%s/%s", image, name)
 	}
-	uid_str := user_struct.Uid
-	gid_str := user_struct.Gid
-	group_struct, err := group.LookupGroupId(gid_str)
+	return name
+}
+
+// run the build in a container of the image
+func run_build(image string, stdout io.Writer, stderr io.Writer) (ret_val int, usage resource_usage, err error) {
+	dw, err := docker_wrapper.New()
 	if err != nil {
-		return err
+		return -1, usage, err
 	}
-	groupname := group_struct.Name
+	configure_wrapper(dw, image)
+	dw.Stdout = stdout
+	dw.Stderr = stderr
 
-	log.Debugf("Detected user=%s (%s) group=%s (%s)", username, uid_str, groupname, gid_str)
+	// start sidecar services in a network of this build
+	network, err := start_services(image, config.services)
+	if err != nil {
+		return -1, usage, err
+	}
+	teardown_network := add_teardown(func() { network.stop(dw) })
+	defer run_teardown(teardown_network)
+	dw.NetworkMode = network.network_mode()
+
+	// create the docker container
+	phase := time.Now()
+	err = dw.Create()
+	audit_phase(build_phase_name(image, "container_create"), phase)
+	if err != nil {
+		return -1, usage, fmt.Errorf("Docker error: %s", err)
+	}
+	audit_record_container(dw)
 
-	user_group := fmt.Sprintf("%s:%s", username, groupname)
+	// the container is removed on aborts, the build decides to keep it otherwise
+	teardown_container := add_teardown(func() { remove_container(dw) })
+	defer drop_teardown(teardown_container)
 
-	// remove existing uid
-	stdout, _, ret_val, _ := run_command(dw, []string{"getent", "passwd", uid_str})
-	if ret_val == 0 {
-		lines := strings.Split(strings.TrimSpace(stdout), "\n")
-		for i := range lines {
-			user := strings.Split(lines[i], ":")
-			log.Infof("Remove user '%s'", user[0])
-			_, _, _, err := run_command_expect(dw, []string{"userdel", user[0]}, 0)
-			if err != nil {
-				return err
-			}
-		}
+	phase = time.Now()
+	provisioning, err := init_container(dw)
+	audit_phase(build_phase_name(image, "init_container"), phase)
+	if err != nil {
+		run_teardown(teardown_container)
+		return -1, usage, err
 	}
-	// remove existing user
-	_, _, ret_val, _ = run_command(dw, []string{"getent", "passwd", username})
-	if ret_val == 0 {
-		log.Infof("Remove user '%s'", username)
-		_, _, _, err := run_command_expect(dw, []string{"userdel", username}, 0)
-		if err != nil {
-			return err
-		}
+	ca, err := inject_ca_certificates(dw, config.ca_certificates)
+	if err != nil {
+		run_teardown(teardown_container)
+		return -1, usage, err
 	}
 
-	// remove existing gid
-	stdout, _, ret_val, _ = run_command(dw, []string{"getent", "group", gid_str})
-	if ret_val == 0 {
-		lines := strings.Split(strings.TrimSpace(stdout), "\n")
-		for i := range lines {
-			group := strings.Split(lines[i], ":")
-			log.Infof("Remove group '%s'", group[0])
-			_, _, _, err := run_command_expect(dw, []string{"groupdel", group[0]}, 0)
-			if err != nil {
-				return err
-			}
-		}
+	// start the docker container
+	phase = time.Now()
+	_, err = dw.Start()
+	audit_phase(build_phase_name(image, "container_start"), phase)
+	if err != nil {
+		run_teardown(teardown_container)
+		return -1, usage, fmt.Errorf("Docker error: %s", err)
+	}
+	err = verify_mounts(dw, config.mount_checks)
+	if err != nil {
+		run_teardown(teardown_container)
+		return -1, usage, err
 	}
 
-	// remove existing group
-	_, _, ret_val, _ = run_command(dw, []string{"getent", "group", groupname})
-	if ret_val == 0 {
-		log.Infof("Remove group '%s'", groupname)
-		_, _, _, err := run_command_expect(dw, []string{"groupdel", groupname}, 0)
-		if err != nil {
-			return err
-		}
+	phase = time.Now()
+	err = provisioning.provision_started()
+	audit_phase(build_phase_name(image, "provision_user"), phase)
+	if err != nil {
+		run_teardown(teardown_container)
+		return -1, usage, err
 	}
 
-	// add group
-	_, _, _, err = run_command_expect(dw, []string{"groupadd", "-g", gid_str, groupname}, 0)
+	phase = time.Now()
+	err = ca.update()
+	audit_phase(build_phase_name(image, "ca_certificates"), phase)
 	if err != nil {
-		return err
+		run_teardown(teardown_container)
+		return -1, usage, err
 	}
 
-	// add user
-	_, _, _, err = run_command_expect(dw, []string{"useradd", "-d", jenkins_home_path, "-g", gid_str, "-u", uid_str, username}, 0)
+	phase = time.Now()
+	err = deliver_secret_files(dw, config.secret_files)
+	audit_phase(build_phase_name(image, "secret_files"), phase)
 	if err != nil {
-		return err
+		run_teardown(teardown_container)
+		return -1, usage, err
 	}
 
-	// resets sudoers file
-	_, _, _, err = run_command_expect(dw, []string{"sh", "-c", "echo \"root ALL=(ALL:ALL) ALL\" > /etc/sudoers"}, 0)
+	// watch for oom kills during the build
+	oom, err := watch_oom(dw)
 	if err != nil {
-		return err
+		log.Warnf("Can't subscribe to docker events: %s", err)
 	}
+	defer oom.stop()
 
-	// move ssh known hosts file
-	_, _, _, err = run_command_expect(dw, []string{"mkdir", "-p", ssh_dir_path}, 0)
+	// call jenkins script
+	command := build_command()
+	stats := collect_stats(dw)
+	defer stats.stop()
+	phase = time.Now()
+	ret_val, err = dw.RunCommandAttach(command, false)
+	audit_phase(build_phase_name(image, "build"), phase)
 	if err != nil {
-		return err
+		run_teardown(teardown_container)
+		return -1, usage, err
+	}
+
+	// report resource usage
+	usage = stats.stop()
+	usage.JobName = config.job_name
+	usage.BuildID = config.build_id
+	usage.ImageName = dw.ImageName
+	usage.report()
+
+	// report oom kills
+	if oom != nil {
+		killed, memory_limit := oom.oom_killed(ret_val)
+		oom.stop()
+		if killed {
+			log.Error(oom_message(memory_limit))
+			ret_val = exit_code_oom_killed
+		}
 	}
-	_, _, _, err = run_command_expect(dw, []string{"mv", "/tmp/known_hosts", ssh_known_hosts_path}, 0)
+
+	// clean up container, kept containers and debug images keep no secrets
+	wipe_secret_files(dw, config.secret_files)
+	err = dw.Stop()
 	if err != nil {
-		return err
+		log.Warn(err)
+	}
+
+	action := container_action(ret_val, *args.no_rm, *args.keep_on_failure, *args.commit_on_failure)
+	if action == container_commit {
+		err = commit_debug_image(dw)
+		if err != nil {
+			log.Warnf("Can't commit debug image: %s", err)
+			action = container_keep
+		} else if !*args.no_rm {
+			action = container_remove
+		}
+	}
+
+	if action == container_keep {
+		report_kept_container(dw)
+	} else {
+		// remove container
+		err = dw.Remove()
+		if err != nil {
+			log.Warn(err)
+		}
 	}
 
-	// reset rights in jenkins home
-	_, _, _, err = run_command_expect(dw, []string{"chown", "-R", user_group, jenkins_home_path}, 0)
+	return ret_val, usage, nil
+}
+
+// stop and remove a container after a failure
+func remove_container(dw *docker_wrapper.DockerWrapper) {
+	err := dw.Stop()
 	if err != nil {
-		return err
+		log.Warn(err)
 	}
+	err = dw.Remove()
+	if err != nil {
+		log.Warn(err)
+	}
+}
 
-	return nil
+// write the resource usage into the workspace
+func write_stats_file(usage interface{}) {
+	path, err := workspace_file_path(*args.stats_file)
+	if err == nil {
+		err = as_caller(func() error {
+			return write_json_file(path, usage)
+		})
+	}
+	if err != nil {
+		log.Warnf("Can't write resource usage: %s", err)
+	}
 }
 
 // main function
 func main() {
 	defer cleanup()
 
-	err := initialize()
+	// audit every invocation, even denied or crashed ones
+	audit_start()
+	defer audit_recover()
+
+	// log.Fatal and aborts skip deferred functions
+	log.RegisterExitHandler(cleanup)
+	handle_signals()
+
+	phase := time.Now()
+	err := initialize_config()
 	if err != nil {
 		log.Panic(err)
 	}
 
-	dw, err := docker_wrapper.New()
+	// open a shell in a kept container
+	if config.command == command_attach {
+		ret_val, err := attach()
+		if err != nil {
+			log.Panic(err)
+		}
+		audit_write(ret_val)
+		os.Exit(ret_val)
+	}
+
+	err = initialize()
+	audit_phase("initialize", phase)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	dw.ImageName = *args.image_name
-	dw.Volumes = config.volumes
-	dw.Environment = config.environment
-	dw.WorkingDir = config.workspace_path
-	// Starting the docker container
-	err = dw.Run()
-	if err != nil {
-		log.Fatalf("Docker error: %s", err)
+	// print the container specification without contacting docker
+	if *args.dry_run {
+		err = dry_run(os.Stdout)
+		if err != nil {
+			log.Panic(err)
+		}
+		audit_write(0)
+		os.Exit(0)
 	}
 
-	err = init_container(dw)
+	dw, err := docker_wrapper.New()
 	if err != nil {
-		log.Fatal(err)
+		log.Panic(err)
 	}
 
-	// call jenkins script
-	command := []string{"sudo", "-E", "-u", "jenkins", "bash"}
-	command = append(command, config.container_args...)
-	ret_val, err := dw.RunCommandAttach(command, false)
-	if err != nil {
-		log.Fatal(err)
+	// expire kept containers and debug images
+	reap_kept(dw, config.keep_retention)
+
+	// build the image of the workspace Dockerfile
+	if config.dockerfile != "" {
+		err = build_workspace_image(config.images[0], os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	// clean up container
-	err = dw.Stop()
-	if err != nil {
-		log.Warn(err)
+	// run the same script in multiple images
+	if len(config.images) > 1 {
+		ret_val := run_matrix(os.Stdout, os.Stderr)
+		cleanup()
+		audit_write(ret_val)
+		os.Exit(ret_val)
 	}
 
-	// remove container
-	err = dw.Remove()
+	// mask secrets in the build output
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	if config.mask_build_output {
+		stdout = new_line_writer(os.Stdout, secrets.mask)
+		stderr = new_line_writer(os.Stderr, secrets.mask)
+	}
+
+	ret_val, usage, err := run_build(config.images[0], stdout, stderr)
+	flush_writers(stdout, stderr)
 	if err != nil {
-		log.Warn(err)
+		log.Fatal(err)
+	}
+
+	if *args.stats_file != "" {
+		write_stats_file(usage)
 	}
 
+	cleanup()
+	audit_write(ret_val)
 	os.Exit(ret_val)
 
 }
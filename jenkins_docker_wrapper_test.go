@@ -129,15 +129,18 @@ func TestEnvironmentBuildUser(t *testing.T) {
 		"Valid user doesn't get filtered",
 	)
 
+	// the environment can't change the user
 	env, err = build_environment([]string{
 		"USER=invalid",
 	})
-	assert.NotEqual(t, nil, err, "Do return a error")
+	assert.Equal(t, nil, err, "Doesn't return a error")
 	assert.Equal(
 		t,
-		[]string{},
+		[]string{
+			fmt.Sprintf("USER=%s", valid_user),
+		},
 		env,
-		"Error build has to return empty array",
+		"User environment is not replaced by the real user",
 	)
 }
 
@@ -188,7 +191,7 @@ func TestEnvironmentBuildWorkspace(t *testing.T) {
 	)
 
 	env, err = build_environment([]string{
-		fmt.Sprintf("%s=/jenkins/kunde1", key, valid_path),
+		fmt.Sprintf("%s=/jenkins/kunde1", key),
 	})
 	assert.NotEqual(t, nil, err, "Do return a error")
 	assert.Equal(
@@ -210,7 +213,7 @@ func TestEnvironmentBuildWorkspace(t *testing.T) {
 	)
 
 	env, err = build_environment([]string{
-		fmt.Sprintf("%s=jenkins/kunde1", key, valid_path),
+		fmt.Sprintf("%s=jenkins/kunde1", key),
 	})
 	assert.NotEqual(t, nil, err, "Do return a error")
 	assert.Equal(
@@ -231,12 +234,13 @@ func TestEnvironmentBuildSshAuthSock(t *testing.T) {
 	assert.Equal(
 		t,
 		[]string{
-			fmt.Sprintf("%s=%s", key, value),
+			fmt.Sprintf("%s=/tmp/%s/%s", key, ssh_agent_dir_name, ssh_agent_socket_name),
 		},
 		env,
-		"Error path has to be within the workspace",
+		"Socket has to be replaced by the socket of the build",
 	)
-	assert.Equal(t, true, stringInSlice(value, config.tmp_files_to_move), "Path is not copied to the container copy list")
+	assert.Equal(t, value, config.ssh_auth_sock, "Socket is not forwarded")
+	assert.Equal(t, false, stringInSlice(value, config.tmp_files_to_move), "Socket must not be moved")
 
 	env, err = build_environment([]string{
 		fmt.Sprintf("%s=relative/%s", key, value),
@@ -296,3 +300,16 @@ func TestEnvironmentBuildJobName(t *testing.T) {
 	)
 	assert.Equal(t, value, config.job_name, "job_name is not copied to config")
 }
+
+func TestOverrideEnvironment(t *testing.T) {
+	env := override_environment(
+		[]string{"SSL_CERT_FILE=/home/jenkins/ca.pem", "PATH=/usr/bin"},
+		[]string{"SSL_CERT_FILE=/etc/jenkins_docker_wrapper/ca-bundle.crt"},
+	)
+	assert.Equal(
+		t,
+		[]string{"PATH=/usr/bin", "SSL_CERT_FILE=/etc/jenkins_docker_wrapper/ca-bundle.crt"},
+		env,
+		"Inherited variable not overridden",
+	)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"github.com/fsouza/go-dockerclient"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// labels of containers and images created by the wrapper
+const label_prefix = "de.former03.jenkins_docker_wrapper."
+
+const (
+	label_managed     = label_prefix + "managed"
+	label_job_name    = label_prefix + "job_name"
+	label_build_id    = label_prefix + "build_id"
+	label_uid         = label_prefix + "uid"
+	label_image       = label_prefix + "image"
+	label_debug_image = label_prefix + "debug_image"
+	label_shell       = label_prefix + "shell"
+)
+
+// repository of images committed from failed builds
+const debug_image_repository = "jenkins_docker_wrapper_debug"
+
+const default_keep_retention = "24h"
+
+// what happens to the container after the build
+const (
+	container_remove = "remove"
+	container_keep   = "keep"
+	container_commit = "commit"
+)
+
+var invalid_repository_chars = regexp.MustCompile("[^a-z0-9._-]+")
+
+// labels of the build container
+func container_labels(uid int, image string) map[string]string {
+	return map[string]string{
+		label_managed:  "true",
+		label_job_name: config.job_name,
+		label_build_id: strconv.Itoa(config.build_id),
+		label_uid:      strconv.Itoa(uid),
+		label_image:    image,
+		label_shell:    config.default_shell,
+	}
+}
+
+// decide what happens to the container after the build
+func container_action(ret_val int, no_rm bool, keep_on_failure bool, commit_on_failure bool) string {
+	if ret_val != 0 && commit_on_failure {
+		return container_commit
+	}
+	if no_rm || (ret_val != 0 && keep_on_failure) {
+		return container_keep
+	}
+	return container_remove
+}
+
+// lower case name only containing characters valid in repositories and tags
+func sanitize_image_name(name string) string {
+	name = invalid_repository_chars.ReplaceAllString(strings.ToLower(name), "_")
+	return strings.Trim(name, "._-")
+}
+
+// repository and tag of the debug image of a build, variant tells apart matrix builds
+func debug_image_name(job_name string, build_id int, variant string) (string, string) {
+	name := sanitize_image_name(job_name)
+	if name == "" {
+		name = "unknown"
+	}
+	tag := "latest"
+	if build_id > 0 {
+		tag = strconv.Itoa(build_id)
+	}
+	if variant = sanitize_image_name(variant); variant != "" {
+		tag = tag + "-" + variant
+	}
+	return debug_image_repository + "/" + name, tag
+}
+
+// unix timestamp a container was kept at, labels can't change after the creation, the
+// container is stopped after the build and after every attach
+func container_kept_at(container *docker.Container) int64 {
+	if container.State.FinishedAt.IsZero() {
+		return container.Created.Unix()
+	}
+	return container.State.FinishedAt.Unix()
+}
+
+// test if a resource created at the unix timestamp is older than the retention
+func retention_expired(created int64, now time.Time, retention time.Duration) bool {
+	return now.Sub(time.Unix(created, 0)) > retention
+}
+
+// commit the container of a failed build to a labeled debug image
+func commit_debug_image(dw *docker_wrapper.DockerWrapper) error {
+	variant := ""
+	if len(config.images) > 1 {
+		variant = dw.ImageName
+	}
+	repository, tag := debug_image_name(config.job_name, config.build_id, variant)
+	labels := map[string]string{
+		label_debug_image: "true",
+	}
+	_, err := dw.Commit(repository, tag, labels)
+	if err != nil {
+		return err
+	}
+	log.Infof("Committed failed build to image %s:%s, inspect it with:", repository, tag)
+	log.Infof("  docker run --rm -it --entrypoint %s %s:%s", config.default_shell, repository, tag)
+	return nil
+}
+
+// tell the user how to get into a kept container
+func report_kept_container(dw *docker_wrapper.DockerWrapper) {
+	log.Infof("Kept container %s, inspect it with:", dw.ContainerID())
+	log.Infof("  %s %s %q %d", attach_executable(os.Args[0]), command_attach, config.job_name, config.build_id)
+}
+
+// executable to attach with, the legacy name has no subcommands
+func attach_executable(executable string) string {
+	if parse_arguments_legacy(filepath.Base(executable)) {
+		return filepath.Join(filepath.Dir(executable), "jenkins_docker_wrapper")
+	}
+	return executable
+}
+
+// remove kept containers and debug images older than the retention
+func reap_kept(dw *docker_wrapper.DockerWrapper, retention time.Duration) {
+	now := time.Now()
+
+	containers, err := dw.ListContainersByLabel(label_managed)
+	if err != nil {
+		log.Warnf("Can't list kept containers: %s", err)
+	}
+	for _, container := range containers {
+		if container.State == "running" || strings.HasPrefix(container.Status, "Up") {
+			continue
+		}
+		inspected, err := dw.InspectContainerID(container.ID)
+		if err != nil {
+			log.Warnf("Can't inspect kept container %s: %s", container.ID, err)
+			continue
+		}
+		if !retention_expired(container_kept_at(inspected), now, retention) {
+			continue
+		}
+		log.Infof("Remove expired container %s of job '%s'", container.ID, container.Labels[label_job_name])
+		err = dw.RemoveContainerID(container.ID)
+		if err != nil {
+			log.Warnf("Can't remove expired container %s: %s", container.ID, err)
+		}
+	}
+
+	images, err := dw.ListImagesByLabel(label_debug_image)
+	if err != nil {
+		log.Warnf("Can't list debug images: %s", err)
+	}
+	for _, image := range images {
+		if !retention_expired(image.Created, now, retention) {
+			continue
+		}
+		log.Infof("Remove expired debug image %s", image.ID)
+		err := dw.RemoveImage(image.ID)
+		if err != nil {
+			log.Warnf("Can't remove expired debug image %s: %s", image.ID, err)
+		}
+	}
+}
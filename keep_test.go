@@ -0,0 +1,66 @@
+package main
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestContainerAction(t *testing.T) {
+	assert.Equal(t, container_remove, container_action(0, false, false, false), "Successful build has to be removed")
+	assert.Equal(t, container_remove, container_action(1, false, false, false), "Failed build has to be removed by default")
+	assert.Equal(t, container_keep, container_action(0, true, false, false), "no_rm has to keep the container")
+	assert.Equal(t, container_remove, container_action(0, false, true, true), "Successful build has to be removed")
+	assert.Equal(t, container_keep, container_action(1, false, true, false), "Failed build has to be kept")
+	assert.Equal(t, container_commit, container_action(1, false, true, true), "Failed build has to be committed")
+}
+
+func TestDebugImageName(t *testing.T) {
+	repository, tag := debug_image_name("Kunde1/My Project", 42, "")
+	assert.Equal(t, "jenkins_docker_wrapper_debug/kunde1_my_project", repository, "Repository not sanitized")
+	assert.Equal(t, "42", tag, "Tag not correct")
+
+	repository, tag = debug_image_name("", 0, "")
+	assert.Equal(t, "jenkins_docker_wrapper_debug/unknown", repository, "Empty job name not handled")
+	assert.Equal(t, "latest", tag, "Missing build id not handled")
+
+	_, tag = debug_image_name("kunde1", 42, "debian:jessie")
+	assert.Equal(t, "42-debian_jessie", tag, "Matrix variant not in tag")
+}
+
+func TestRetentionExpired(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, true, retention_expired(now.Add(-25*time.Hour).Unix(), now, 24*time.Hour), "Old resource not expired")
+	assert.Equal(t, false, retention_expired(now.Add(-time.Hour).Unix(), now, 24*time.Hour), "New resource expired")
+}
+
+func TestContainerKeptAt(t *testing.T) {
+	created := time.Now().Add(-48 * time.Hour)
+	finished := time.Now().Add(-time.Hour)
+	container := &docker.Container{Created: created}
+	assert.Equal(t, created.Unix(), container_kept_at(container), "Creation not used for a container never run")
+
+	// a long build is kept when it finished
+	container.State.FinishedAt = finished
+	assert.Equal(t, finished.Unix(), container_kept_at(container), "Finish of the build not used")
+	assert.Equal(t, false, retention_expired(container_kept_at(container), time.Now(), 24*time.Hour), "Long build expired early")
+}
+
+func TestAttachExecutable(t *testing.T) {
+	assert.Equal(t, "/opt/bin/my_wrapper", attach_executable("/opt/bin/my_wrapper"), "Executable not used")
+	assert.Equal(t, "/usr/bin/jenkins_docker_wrapper", attach_executable("/usr/bin/jenkins_docker_run"), "Legacy name used")
+}
+
+func TestContainerLabels(t *testing.T) {
+	config.job_name = "kunde1"
+	config.build_id = 7
+	config.default_shell = "/bin/zsh"
+	labels := container_labels(1000, "debian:jessie")
+	assert.Equal(t, "true", labels[label_managed], "Managed label missing")
+	assert.Equal(t, "kunde1", labels[label_job_name], "Job name label not correct")
+	assert.Equal(t, "7", labels[label_build_id], "Build id label not correct")
+	assert.Equal(t, "1000", labels[label_uid], "Uid label not correct")
+	assert.Equal(t, "debian:jessie", labels[label_image], "Image label not correct")
+	assert.Equal(t, "/bin/zsh", labels[label_shell], "Shell label not correct")
+}
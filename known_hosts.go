@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// default known_hosts of all builds, maintained by root
+const default_ssh_known_hosts = "/etc/jenkins_docker_wrapper_known_hosts"
+
+// system wide ssh_config of the image, owned by root
+const ssh_config_path = "/etc/ssh/ssh_config"
+
+// ssh_config injected with ssh_strict_host_key_checking
+const ssh_config_strict = `Host *
+    StrictHostKeyChecking yes
+    UserKnownHostsFile ~/.ssh/known_hosts
+`
+
+// ssh_config of the image with the strict settings first, ssh uses the first value it reads
+func strict_ssh_config(content []byte) []byte {
+	return append([]byte(ssh_config_strict), content...)
+}
+
+// check a known_hosts line of a job, markers like @cert-authority are reserved to the config file
+func check_known_hosts_line(line string) error {
+	// a line break would smuggle further entries like @cert-authority into the file
+	if strings.ContainsAny(line, "\n\r\x00") {
+		return fmt.Errorf("Invalid known_hosts entry %q, line breaks and NUL are not allowed", line)
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 3 || strings.HasPrefix(fields[0], "@") || strings.HasPrefix(fields[0], "#") {
+		return fmt.Errorf("Invalid known_hosts entry '%s', expected 'hosts keytype key'", line)
+	}
+	return nil
+}
+
+// read the known_hosts file of the config, it has to be owned and only writable by root
+func read_policy_known_hosts(path string) ([]byte, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		log.Debugf("No known_hosts file %s", path)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.Mode().IsRegular() || !ok || stat.Uid != 0 || info.Mode().Perm()&0022 != 0 {
+		return nil, fmt.Errorf("Invalid known_hosts file %s, expected a regular file only writable by root", path)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// known_hosts of the build from the config file and the entries of the job
+func assemble_known_hosts(path string, additions []string) ([]byte, error) {
+	content, err := read_policy_known_hosts(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(content)
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		buf.WriteString("\n")
+	}
+	for _, line := range additions {
+		err = check_known_hosts_line(line)
+		audit_policy("known_hosts", line, err)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(strings.TrimSpace(line) + "\n")
+	}
+	return buf.Bytes(), nil
+}
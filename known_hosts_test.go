@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckKnownHostsLine(t *testing.T) {
+	assert.Equal(t, nil, check_known_hosts_line("github.com,192.30.252.1 ssh-rsa AAAAB3NzaC1yc2E"), "Do not return a error")
+	assert.NotEqual(t, nil, check_known_hosts_line("github.com"), "Line without key accepted")
+	assert.NotEqual(t, nil, check_known_hosts_line("@cert-authority *.example.com ssh-rsa AAAAB3NzaC1yc2E"), "Marker accepted from a job")
+	assert.NotEqual(t, nil, check_known_hosts_line("github.com ssh-rsa AAAAB3NzaC1yc2E\n@cert-authority * ssh-rsa AAAAB3NzaC1yc2E"), "Line break accepted")
+	assert.NotEqual(t, nil, check_known_hosts_line("github.com ssh-rsa AAAAB3NzaC1yc2E\r@revoked * ssh-rsa AAAAB3NzaC1yc2E"), "Carriage return accepted")
+	assert.NotEqual(t, nil, check_known_hosts_line("github.com ssh-rsa AAAAB3NzaC1yc2E\x00"), "NUL accepted")
+}
+
+func TestStrictSshConfig(t *testing.T) {
+	content := string(strict_ssh_config([]byte("Host *\n    StrictHostKeyChecking no\n")))
+	assert.Equal(t, true, strings.HasPrefix(content, ssh_config_strict), "Strict settings not first")
+	assert.Equal(t, true, strings.HasSuffix(content, "StrictHostKeyChecking no\n"), "Config of the image not kept")
+	assert.Equal(t, ssh_config_strict, string(strict_ssh_config([]byte{})), "Missing config of the image not handled")
+}
+
+func TestAssembleKnownHosts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+
+	// a missing file of the config is no error
+	content, err := assemble_known_hosts(filepath.Join(dir, "missing"), []string{"git.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5"})
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "git.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5\n", string(content), "Entries of the job not added")
+
+	_, err = assemble_known_hosts(filepath.Join(dir, "missing"), []string{"invalid"})
+	assert.NotEqual(t, nil, err, "Invalid entry of the job accepted")
+
+	// a file writable by others is not trusted
+	path := filepath.Join(dir, "known_hosts")
+	ioutil.WriteFile(path, []byte("github.com ssh-rsa AAAAB3NzaC1yc2E"), 0644)
+	os.Chmod(path, 0666)
+	_, err = assemble_known_hosts(path, []string{})
+	assert.NotEqual(t, nil, err, "Writable known_hosts file accepted")
+
+	// only root owned files are trusted
+	os.Chmod(path, 0644)
+	content, err = assemble_known_hosts(path, []string{"git.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5"})
+	if os.Getuid() == 0 {
+		assert.Equal(t, nil, err, "Do not return a error")
+		assert.Equal(t, "github.com ssh-rsa AAAAB3NzaC1yc2E\ngit.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5\n", string(content), "Known hosts not assembled")
+	} else {
+		assert.NotEqual(t, nil, err, "Known_hosts file of a user accepted")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// prefix of wrapper messages to tell them apart from the build output
+const log_prefix = "[jenkins_docker_wrapper] "
+
+// field of json wrapper messages to tell them apart from the build output
+const (
+	log_source_field = "source"
+	log_source       = "wrapper"
+)
+
+const default_log_level = "info"
+
+const default_log_format = "text"
+
+// logrus formatter that prefixes every line
+type prefix_formatter struct {
+	prefix    string
+	formatter log.Formatter
+}
+
+func (f *prefix_formatter) Format(entry *log.Entry) ([]byte, error) {
+	b, err := f.formatter.Format(entry)
+	if err != nil {
+		return b, err
+	}
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	out := new(bytes.Buffer)
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		out.WriteString(f.prefix)
+		out.Write(line)
+	}
+	return out.Bytes(), nil
+}
+
+// logrus formatter that adds the source to every entry
+type source_formatter struct {
+	formatter log.Formatter
+}
+
+func (f *source_formatter) Format(entry *log.Entry) ([]byte, error) {
+	tagged := *entry
+	tagged.Data = log.Fields{}
+	for key, value := range entry.Data {
+		tagged.Data[key] = value
+	}
+	tagged.Data[log_source_field] = log_source
+	return f.formatter.Format(&tagged)
+}
+
+// create the log formatter for a format name
+func new_log_formatter(format string) (log.Formatter, error) {
+	var formatter log.Formatter
+	switch format {
+	case "text":
+		formatter = &prefix_formatter{
+			prefix:    log_prefix,
+			formatter: &log.TextFormatter{},
+		}
+	case "json":
+		formatter = &source_formatter{formatter: &log.JSONFormatter{}}
+	default:
+		return nil, fmt.Errorf("Invalid log format '%s', expected to be text or json", format)
+	}
+
+	// redact secrets from all log messages
+	return &masking_formatter{formatter: formatter}, nil
+}
+
+// return the first non empty value
+func first_non_empty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// the log file of the caller has to be an absolute path, access is checked by opening it as the caller
+func check_log_file_path(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("Invalid log file '%s', expected to be absolute path", path)
+	}
+	return nil
+}
+
+// set up the level, format and destination of the wrapper log
+func configure_logging(level string, format string, file string) error {
+	log_level, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	formatter, err := new_log_formatter(format)
+	if err != nil {
+		return err
+	}
+
+	if file != "" {
+		// a symlink planted at the log file is not followed
+		out, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE|syscall.O_NOFOLLOW, 0640)
+		if err != nil {
+			return fmt.Errorf("Log file '%s' is not writable: %s", file, err)
+		}
+		log.SetOutput(out)
+	}
+
+	log.SetLevel(log_level)
+	log.SetFormatter(formatter)
+	return nil
+}
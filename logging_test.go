@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoggingPrefix(t *testing.T) {
+	f := &prefix_formatter{
+		prefix:    log_prefix,
+		formatter: &log.TextFormatter{DisableColors: true},
+	}
+	b, err := f.Format(log.WithField("key", "value"))
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, true, strings.HasPrefix(string(b), log_prefix), "Log line not prefixed")
+	assert.Equal(t, 1, strings.Count(string(b), log_prefix), "Prefix added more than once")
+}
+
+func TestLoggingSource(t *testing.T) {
+	secrets = secret_masker{}
+	f, err := new_log_formatter("json")
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	entry := log.WithField("key", "value")
+	entry.Message = "message"
+	b, err := f.Format(entry)
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	var fields map[string]string
+	err = json.Unmarshal(b, &fields)
+	assert.Equal(t, nil, err, "Log entry is not valid JSON")
+	assert.Equal(t, log_source, fields[log_source_field], "Source of the wrapper missing")
+	assert.Equal(t, "value", fields["key"], "Fields of the entry missing")
+	_, ok := entry.Data[log_source_field]
+	assert.Equal(t, false, ok, "Fields of the entry modified")
+}
+
+func TestLoggingFormatter(t *testing.T) {
+	_, err := new_log_formatter("text")
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	_, err = new_log_formatter("json")
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	_, err = new_log_formatter("xml")
+	assert.NotEqual(t, nil, err, "Invalid format accepted")
+}
+
+func TestLoggingConfigure(t *testing.T) {
+	err := configure_logging("warning", "text", "")
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, log.WarnLevel, log.GetLevel(), "Log level not set")
+
+	err = configure_logging("verbose", "text", "")
+	assert.NotEqual(t, nil, err, "Invalid level accepted")
+
+	err = configure_logging("info", "text", "")
+	assert.Equal(t, nil, err, "Do not return a error")
+}
+
+func TestLoggingFirstNonEmpty(t *testing.T) {
+	assert.Equal(t, "b", first_non_empty("", "b", "c"), "First value not correct")
+	assert.Equal(t, "", first_non_empty("", ""), "Empty values not handled")
+}
+
+func TestConfigureLoggingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+	defer log.SetOutput(os.Stderr)
+
+	assert.NotEqual(t, nil, check_log_file_path("wrapper.log"), "Relative log file accepted")
+
+	// a planted symlink is not followed
+	target := filepath.Join(dir, "target")
+	ioutil.WriteFile(target, []byte{}, 0644)
+	link := filepath.Join(dir, "wrapper.log")
+	os.Symlink(target, link)
+	err = configure_logging("info", "text", link)
+	assert.NotEqual(t, nil, err, "Symlink followed")
+
+	err = configure_logging("info", "text", filepath.Join(dir, "new.log"))
+	assert.Equal(t, nil, err, "Do not return a error")
+}
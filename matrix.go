@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// default upper limit of parallel matrix builds
+const default_max_parallel = 2
+
+// exit code returned if any matrix build failed
+const exit_code_matrix_failed = 1
+
+// result of a single matrix build
+type matrix_result struct {
+	Image    string
+	ExitCode int
+	Duration time.Duration
+	Error    error
+	Usage    resource_usage
+}
+
+func (r *matrix_result) failed() bool {
+	return r.Error != nil || r.ExitCode != 0
+}
+
+// run the build in all images, limited to config.parallel builds at once
+func run_matrix(stdout io.Writer, stderr io.Writer) int {
+	results := make([]matrix_result, len(config.images))
+	slots := make(chan bool, config.parallel)
+	var wg sync.WaitGroup
+
+	for i, image := range config.images {
+		wg.Add(1)
+		go func(i int, image string) {
+			defer wg.Done()
+			slots <- true
+			defer func() { <-slots }()
+
+			// prefix every line of the build output with the image name
+			transform := func(line string) string { return line }
+			if config.mask_build_output {
+				transform = secrets.mask
+			}
+			prefix := fmt.Sprintf("[%s] ", image)
+			out := new_line_writer(stdout, prefix_lines(prefix, transform))
+			err_out := new_line_writer(stderr, prefix_lines(prefix, transform))
+
+			log.Infof("Starting build in image %s", image)
+			start := time.Now()
+			ret_val, usage, err := run_build(image, out, err_out)
+			flush_writers(out, err_out)
+
+			results[i] = matrix_result{
+				Image:    image,
+				ExitCode: ret_val,
+				Duration: time.Since(start),
+				Error:    err,
+				Usage:    usage,
+			}
+			if err != nil {
+				log.Errorf("Build in image %s failed: %s", image, err)
+			}
+		}(i, image)
+	}
+	wg.Wait()
+
+	print_matrix_summary(stdout, results)
+
+	if *args.stats_file != "" {
+		usages := []resource_usage{}
+		for _, result := range results {
+			usages = append(usages, result.Usage)
+		}
+		write_stats_file(usages)
+	}
+
+	return matrix_exit_code(results)
+}
+
+// exit code of the whole matrix
+func matrix_exit_code(results []matrix_result) int {
+	for _, result := range results {
+		if result.failed() {
+			return exit_code_matrix_failed
+		}
+	}
+	return 0
+}
+
+// print a table with the result of every image
+func print_matrix_summary(w io.Writer, results []matrix_result) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "IMAGE\tRESULT\tEXIT CODE\tDURATION")
+	for _, result := range results {
+		status := "success"
+		if result.Error != nil {
+			status = "error"
+		} else if result.failed() {
+			status = "failed"
+		}
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%d\t%s\n",
+			result.Image,
+			status,
+			result.ExitCode,
+			result.Duration-result.Duration%time.Second,
+		)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArgumentsMatrix(t *testing.T) {
+	parse_arguments([]string{"myname", "-i", "debian", "--image_name", "alpine", "--parallel", "3", "--", "myscript"})
+	assert.Equal(t, []string{"debian", "alpine"}, *args.image_names, "Image names not correct")
+	assert.Equal(t, "debian", *args.image_name, "First image name not correct")
+	assert.Equal(t, 3, *args.parallel, "Parallel not correct")
+}
+
+func TestMatrixSummary(t *testing.T) {
+	results := []matrix_result{
+		{Image: "debian", ExitCode: 0, Duration: 3 * time.Second},
+		{Image: "alpine", ExitCode: 2, Duration: time.Minute},
+		{Image: "centos", ExitCode: -1, Error: errors.New("pull failed")},
+	}
+
+	out := new(bytes.Buffer)
+	print_matrix_summary(out, results)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 4, len(lines), "Summary has to contain a line per image")
+	assert.Contains(t, lines[1], "success", "Successful build not reported")
+	assert.Contains(t, lines[2], "failed", "Failed build not reported")
+	assert.Contains(t, lines[3], "error", "Errored build not reported")
+
+	assert.Equal(t, exit_code_matrix_failed, matrix_exit_code(results), "Failed matrix has to fail")
+	assert.Equal(t, 0, matrix_exit_code(results[:1]), "Successful matrix has to succeed")
+}
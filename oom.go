@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"github.com/fsouza/go-dockerclient"
+	"sync"
+	"time"
+)
+
+// exit code of a process killed by SIGKILL
+const exit_code_sigkill = 137
+
+// exit code returned if the build got killed by the memory cgroup
+const exit_code_oom_killed = 250
+
+// time to wait for a late oom event after the build has been killed
+const oom_event_grace = 2 * time.Second
+
+type oom_watcher struct {
+	dw       *docker_wrapper.DockerWrapper
+	listener chan *docker.APIEvents
+	seen     chan bool
+	done     chan bool
+	once     sync.Once
+	stopped  sync.Once
+}
+
+// test if a docker event reports an oom kill in the container
+func is_oom_event(event *docker.APIEvents, container_id string) bool {
+	if event == nil {
+		return false
+	}
+	if event.Status != "oom" && event.Action != "oom" {
+		return false
+	}
+	return event.ID == container_id || event.Actor.ID == container_id
+}
+
+// format the message shown to the user after an oom kill
+func oom_message(memory_limit int64) string {
+	if memory_limit <= 0 {
+		return "build was OOM-killed without a container memory limit"
+	}
+	return fmt.Sprintf("build was OOM-killed at %d MB limit", memory_limit/1024/1024)
+}
+
+// subscribe to oom events of the build container
+func watch_oom(dw *docker_wrapper.DockerWrapper) (*oom_watcher, error) {
+	w := &oom_watcher{
+		dw:       dw,
+		listener: make(chan *docker.APIEvents, 16),
+		seen:     make(chan bool),
+		done:     make(chan bool),
+	}
+
+	err := dw.AddEventListener(w.listener)
+	if err != nil {
+		return nil, err
+	}
+
+	go w.listen(dw.ContainerID())
+	return w, nil
+}
+
+// wait for oom events until the watcher stops or the event stream ends
+func (w *oom_watcher) listen(container_id string) {
+	for {
+		select {
+		case event, ok := <-w.listener:
+			// the client closes the listeners when the event stream ends
+			if !ok {
+				log.Debug("Docker event stream ended")
+				return
+			}
+			if is_oom_event(event, container_id) {
+				log.Debugf("Received oom event for container %s", container_id)
+				w.once.Do(func() { close(w.seen) })
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// unsubscribe from docker events and end the listening goroutine, only the first call stops
+func (w *oom_watcher) stop() {
+	if w == nil {
+		return
+	}
+	w.stopped.Do(func() {
+		err := w.dw.RemoveEventListener(w.listener)
+		if err != nil {
+			log.Warnf("Can't remove docker event listener: %s", err)
+		}
+		close(w.done)
+	})
+}
+
+// check if the build has been oom killed, returns the memory limit of the container
+func (w *oom_watcher) oom_killed(ret_val int) (killed bool, memory_limit int64) {
+	killed = false
+	select {
+	case <-w.seen:
+		killed = true
+	default:
+		// the event may arrive after the exec returned
+		if ret_val == exit_code_sigkill {
+			select {
+			case <-w.seen:
+				killed = true
+			case <-time.After(oom_event_grace):
+			}
+		}
+	}
+
+	container, err := w.dw.Inspect()
+	if err != nil {
+		log.Warnf("Can't inspect container: %s", err)
+		return killed, 0
+	}
+	log.Debugf("Container state oom_killed=%t exit_code=%d", container.State.OOMKilled, container.State.ExitCode)
+	if container.State.OOMKilled {
+		killed = true
+	}
+	if container.HostConfig != nil {
+		memory_limit = container.HostConfig.Memory
+	}
+	return killed, memory_limit
+}
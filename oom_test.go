@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestOomEvent(t *testing.T) {
+	id := "abc123"
+
+	assert.Equal(t, false, is_oom_event(nil, id), "nil event is no oom")
+	assert.Equal(t, true, is_oom_event(&docker.APIEvents{Status: "oom", ID: id}, id), "Old style oom event not detected")
+	assert.Equal(
+		t,
+		true,
+		is_oom_event(&docker.APIEvents{Action: "oom", Actor: docker.APIActor{ID: id}}, id),
+		"New style oom event not detected",
+	)
+	assert.Equal(t, false, is_oom_event(&docker.APIEvents{Status: "oom", ID: "other"}, id), "Oom event of other container detected")
+	assert.Equal(t, false, is_oom_event(&docker.APIEvents{Status: "die", ID: id}, id), "Die event detected as oom")
+}
+
+func TestOomMessage(t *testing.T) {
+	assert.Equal(t, "build was OOM-killed at 512 MB limit", oom_message(512*1024*1024), "Message not correct")
+	assert.Equal(t, "build was OOM-killed without a container memory limit", oom_message(0), "Message not correct")
+}
+
+func TestOomListen(t *testing.T) {
+	w := &oom_watcher{
+		listener: make(chan *docker.APIEvents, 16),
+		seen:     make(chan bool),
+		done:     make(chan bool),
+	}
+	returned := make(chan bool)
+	go func() {
+		w.listen("abc123")
+		close(returned)
+	}()
+
+	w.listener <- &docker.APIEvents{Status: "oom", ID: "abc123"}
+	select {
+	case <-w.seen:
+	case <-time.After(time.Second):
+		t.Error("Oom event not seen")
+	}
+
+	// a closed event stream ends the listener
+	close(w.listener)
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Error("Listener not ended with the event stream")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// flush incomplete lines longer than this
+const line_writer_max_buffer = 4096
+
+// writer that transforms its output line by line
+type line_writer struct {
+	mutex     sync.Mutex
+	out       io.Writer
+	transform func(string) string
+	buf       []byte
+}
+
+func new_line_writer(out io.Writer, transform func(string) string) *line_writer {
+	return &line_writer{
+		out:       out,
+		transform: transform,
+	}
+}
+
+func (w *line_writer) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		_, err := io.WriteString(w.out, w.transform(string(w.buf[:i+1])))
+		w.buf = w.buf[i+1:]
+		if err != nil {
+			return len(p), err
+		}
+	}
+
+	if len(w.buf) > line_writer_max_buffer {
+		return len(p), w.flush()
+	}
+	return len(p), nil
+}
+
+func (w *line_writer) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.out, w.transform(string(w.buf)))
+	w.buf = nil
+	return err
+}
+
+// write out an incomplete last line
+func (w *line_writer) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.flush()
+}
+
+// flush all line writers
+func flush_writers(writers ...io.Writer) {
+	for _, w := range writers {
+		if lw, ok := w.(*line_writer); ok {
+			lw.Flush()
+		}
+	}
+}
+
+// prefix every line with a string
+func prefix_lines(prefix string, transform func(string) string) func(string) string {
+	return func(line string) string {
+		return prefix + transform(line)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	secrets = secret_masker{}
+	secrets.add("hunter22")
+
+	out := new(bytes.Buffer)
+	w := new_line_writer(out, secrets.mask)
+
+	w.Write([]byte("my password is hun"))
+	assert.Equal(t, "", out.String(), "Incomplete line written")
+	w.Write([]byte("ter22\nnext"))
+	assert.Equal(t, "my password is ********\n", out.String(), "Secret split across writes not masked")
+	w.Flush()
+	assert.Equal(t, "my password is ********\nnext", out.String(), "Last line not flushed")
+}
+
+func TestLineWriterPrefix(t *testing.T) {
+	secrets = secret_masker{}
+	secrets.add("hunter22")
+
+	out := new(bytes.Buffer)
+	w := new_line_writer(out, prefix_lines("[debian] ", secrets.mask))
+	w.Write([]byte("line1\nhunter22\n"))
+	flush_writers(w, out)
+	assert.Equal(t, "[debian] line1\n[debian] ********\n", out.String(), "Lines not prefixed")
+}
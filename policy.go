@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// compile the allowed image patterns, they have to match the full image name
+func compile_image_patterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid allowed image pattern '%s': %s", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// test if an image matches the allowed patterns, no patterns allow every image
+func is_image_allowed(patterns []*regexp.Regexp, image string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, re := range patterns {
+		if re.MatchString(image) {
+			return true
+		}
+	}
+	return false
+}
+
+// check an image against the allowed images of the config file
+func check_image_allowed(image string) error {
+	var err error
+	if !is_image_allowed(config.allowed_images, image) {
+		err = fmt.Errorf("Image '%s' is not allowed by the config file", image)
+	}
+	audit_policy("image", image, err)
+	return err
+}
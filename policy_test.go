@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestImageAllowed(t *testing.T) {
+	patterns, err := compile_image_patterns([]string{"debian:.*", "registry\\.example\\.com/.*"})
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	assert.True(t, is_image_allowed(patterns, "debian:jessie"), "Matching image has to be allowed")
+	assert.True(t, is_image_allowed(patterns, "registry.example.com/build/go:1.7"), "Matching image has to be allowed")
+	assert.False(t, is_image_allowed(patterns, "evil/debian:jessie"), "Pattern has to match the full name")
+	assert.False(t, is_image_allowed(patterns, "alpine"), "Other image has to be denied")
+
+	assert.True(t, is_image_allowed(nil, "alpine"), "No patterns allow every image")
+
+	_, err = compile_image_patterns([]string{"debian:("})
+	assert.NotEqual(t, nil, err, "Expect error for an invalid pattern")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// name of the per project config file in the workspace
+const projekt_conf_name = "projekt.conf"
+
+type ProjektConf struct {
+	ImageName string   `json:"image_name"`
+	Matrix    []string `json:"matrix"`
+	Parallel  int      `json:"parallel"`
+
+	Services []ServiceConf `json:"services"`
+
+	Dockerfile string `json:"dockerfile"`
+
+	UserProvisioning string `json:"user_provisioning"`
+
+	JenkinsHome  string `json:"jenkins_home"`
+	DefaultShell string `json:"default_shell"`
+
+	KnownHosts []string `json:"known_hosts"`
+
+	Dns        []string `json:"dns"`
+	DnsSearch  []string `json:"dns_search"`
+	ExtraHosts []string `json:"extra_hosts"`
+	NoProxy    []string `json:"no_proxy"`
+}
+
+func parse_projekt_conf_io(r io.Reader) (pc *ProjektConf, err error) {
+	pc = &ProjektConf{}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &pc)
+	return
+}
+
+func parse_projekt_conf(path string) (pc *ProjektConf, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parse_projekt_conf_io(file)
+}
+
+// images from arguments win over the matrix and image of projekt.conf
+func resolve_images(arg_images []string, pc *ProjektConf) ([]string, error) {
+	images := []string{}
+	for _, image := range arg_images {
+		if image != "" {
+			images = append(images, image)
+		}
+	}
+	if len(images) == 0 && len(pc.Matrix) > 0 {
+		images = append(images, pc.Matrix...)
+	}
+	if len(images) == 0 && pc.ImageName != "" {
+		images = append(images, pc.ImageName)
+	}
+	if len(images) == 0 {
+		return images, errors.New("No image name given, use --image_name or --projekt_conf")
+	}
+	return images, nil
+}
+
+// requested parallelism, limited by the config file
+func resolve_parallel(arg_parallel int, projekt_parallel int, max_parallel int) int {
+	parallel := arg_parallel
+	if parallel <= 0 {
+		parallel = projekt_parallel
+	}
+	if parallel <= 0 || parallel > max_parallel {
+		parallel = max_parallel
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+	return parallel
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseProjektConfIo(t *testing.T) {
+	r := bytes.NewBufferString("{\"image_name\":\"debian:jessie\",\"matrix\":[\"debian:jessie\",\"alpine:3.4\"],\"parallel\":3}")
+	pc, err := parse_projekt_conf_io(r)
+
+	assert.Equal(t, nil, err, "Expect no error")
+	assert.Equal(t, "debian:jessie", pc.ImageName, "Image name not parsed")
+	assert.Equal(t, []string{"debian:jessie", "alpine:3.4"}, pc.Matrix, "Matrix not parsed")
+	assert.Equal(t, 3, pc.Parallel, "Parallel not parsed")
+}
+
+func TestResolveImages(t *testing.T) {
+	pc := &ProjektConf{ImageName: "debian", Matrix: []string{"alpine", "centos"}}
+
+	images, err := resolve_images([]string{"ubuntu"}, pc)
+	assert.Equal(t, nil, err, "Expect no error")
+	assert.Equal(t, []string{"ubuntu"}, images, "Arguments have to win")
+
+	images, err = resolve_images([]string{}, pc)
+	assert.Equal(t, nil, err, "Expect no error")
+	assert.Equal(t, []string{"alpine", "centos"}, images, "Matrix has to win over image name")
+
+	images, err = resolve_images([]string{}, &ProjektConf{ImageName: "debian"})
+	assert.Equal(t, nil, err, "Expect no error")
+	assert.Equal(t, []string{"debian"}, images, "Image name of projekt.conf not used")
+
+	_, err = resolve_images([]string{""}, &ProjektConf{})
+	assert.NotEqual(t, nil, err, "Missing image not detected")
+}
+
+func TestResolveParallel(t *testing.T) {
+	assert.Equal(t, 2, resolve_parallel(0, 0, 2), "Default has to be the maximum")
+	assert.Equal(t, 1, resolve_parallel(1, 3, 2), "Argument has to win")
+	assert.Equal(t, 2, resolve_parallel(0, 5, 2), "Maximum not enforced")
+	assert.Equal(t, 1, resolve_parallel(0, 0, 0), "At least one build has to run")
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// strategies to provision the jenkins user in the build container
+const (
+	provisioning_auto     = "auto"
+	provisioning_existing = "existing"
+	provisioning_passwd   = "passwd"
+	provisioning_shadow   = "shadow"
+	provisioning_busybox  = "busybox"
+)
+
+var provisioning_strategies = []string{
+	provisioning_auto,
+	provisioning_existing,
+	provisioning_passwd,
+	provisioning_shadow,
+	provisioning_busybox,
+}
+
+// directories that can't be the home of the jenkins user
+var forbidden_home_paths = []string{"/", "/bin", "/boot", "/lib", "/lib64", "/root", "/run", "/sbin", "/usr", "/var"}
+
+// trees that can't contain the home of the jenkins user
+var forbidden_home_trees = []string{"/dev", "/etc", "/proc", "/sys", "/tmp"}
+
+// user of provisioning commands, numeric as the image may lack a passwd file
+const provisioning_exec_user = "0:0"
+
+// failed step of a provisioning strategy
+type provisioning_error struct {
+	strategy string
+	step     string
+	err      error
+}
+
+func (e *provisioning_error) Error() string {
+	return fmt.Sprintf("User provisioning '%s' failed at step '%s': %s", e.strategy, e.step, e.err)
+}
+
+// user provisioning of a build container
+type provisioning struct {
+	dw       *docker_wrapper.DockerWrapper
+	strategy string
+	passwd   []byte
+	group    []byte
+}
+
+// validate the strategy of projekt.conf
+func resolve_provisioning(strategy string) (string, error) {
+	strategy = first_non_empty(strategy, provisioning_auto)
+	if !string_in_slice(strategy, provisioning_strategies) {
+		return "", fmt.Errorf("Invalid user provisioning '%s', expected one of %s", strategy, strings.Join(provisioning_strategies, ", "))
+	}
+	return strategy, nil
+}
+
+// validate the home of the jenkins user in the container
+func resolve_container_home(home string) (string, error) {
+	if !filepath.IsAbs(home) {
+		return "", fmt.Errorf("Invalid home '%s', expected to be absolute path", home)
+	}
+	home = filepath.Clean(home)
+	if string_in_slice(home, forbidden_home_paths) {
+		return "", fmt.Errorf("Invalid home '%s', system directories are not allowed", home)
+	}
+	for _, tree := range forbidden_home_trees {
+		if home == tree || strings.HasPrefix(home, tree+"/") {
+			return "", fmt.Errorf("Invalid home '%s', paths within %s are not allowed", home, tree)
+		}
+	}
+	return home, nil
+}
+
+// validate the shell of the jenkins user
+func resolve_shell(shell string) (string, error) {
+	if !filepath.IsAbs(shell) || filepath.Clean(shell) != shell {
+		return "", fmt.Errorf("Invalid shell '%s', expected to be absolute path", shell)
+	}
+	return shell, nil
+}
+
+// test if the passwd file has the user with uid and gid
+func has_user(passwd []byte, username string, uid int, gid int) bool {
+	for _, line := range strings.Split(string(passwd), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) > 3 && fields[0] == username && fields[2] == strconv.Itoa(uid) && fields[3] == strconv.Itoa(gid) {
+			return true
+		}
+	}
+	return false
+}
+
+// select the strategy for an image, an image with the user needs no changes
+func detect_provisioning(strategy string, passwd []byte, username string, uid int, gid int) (string, error) {
+	exists := has_user(passwd, username, uid, gid)
+	switch strategy {
+	case provisioning_auto:
+		if exists {
+			return provisioning_existing, nil
+		}
+		return provisioning_passwd, nil
+	case provisioning_existing:
+		if !exists {
+			return "", &provisioning_error{
+				strategy: strategy,
+				step:     "detect",
+				err:      fmt.Errorf("image has no user '%s' with uid %d and gid %d", username, uid, gid),
+			}
+		}
+	}
+	return strategy, nil
+}
+
+// run a step and report it on failure
+func (p *provisioning) step(name string, fn func() error) error {
+	log.Debugf("User provisioning '%s' step '%s'", p.strategy, name)
+	err := fn()
+	if err != nil {
+		return &provisioning_error{strategy: p.strategy, step: name, err: err}
+	}
+	return nil
+}
+
+// run a command as root in the container as a step
+func (p *provisioning) exec(name string, command ...string) error {
+	return p.step(name, func() error {
+		_, stderr, ret_val, err := p.dw.RunCommandUser(command, provisioning_exec_user)
+		log.Debugf("running command=%v ret_val=%d stderr=%s", command, ret_val, stderr)
+		if err != nil {
+			return err
+		}
+		if ret_val != 0 {
+			return fmt.Errorf("%v exited with code %d: %s", command, ret_val, strings.TrimSpace(stderr))
+		}
+		return nil
+	})
+}
+
+// primary and supplementary groups of the jenkins user
+func (p *provisioning) groups() []host_group {
+	groups := []host_group{{name: config.jenkins_group, gid: config.jenkins_gid}}
+	return append(groups, config.jenkins_groups...)
+}
+
+// test if commands exist in the image
+func (p *provisioning) probe(commands ...string) error {
+	script := fmt.Sprintf("for c in %s; do command -v $c >/dev/null || { echo \"missing $c\" >&2; exit 1; }; done", strings.Join(commands, " "))
+	return p.exec("probe", "sh", "-c", script)
+}
+
+// prepare the created container, files are injected before the start
+func init_container(dw *docker_wrapper.DockerWrapper) (*provisioning, error) {
+	jenkins_home_path := config.container_home
+	uid, gid := config.jenkins_uid, config.jenkins_gid
+	p := &provisioning{dw: dw, strategy: config.user_provisioning}
+
+	log.Debugf("Detected user=%s (%d) group=%s (%d)", config.jenkins_user, uid, config.jenkins_group, gid)
+
+	var err error
+	err = p.step("read_passwd", func() (err error) {
+		p.passwd, err = download_image_file(dw, "/etc/passwd")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = p.step("read_group", func() (err error) {
+		p.group, err = download_image_file(dw, "/etc/group")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.strategy, err = detect_provisioning(p.strategy, p.passwd, config.jenkins_user, uid, gid)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Provision user '%s' with strategy '%s'", config.jenkins_user, p.strategy)
+
+	home := strings.TrimPrefix(jenkins_home_path, "/")
+	entries := []archive_entry{
+		{name: home, dir: true, mode: 0755, uid: uid, gid: gid},
+		{name: filepath.Join(home, ".ssh"), dir: true, mode: 0700, uid: uid, gid: gid},
+	}
+	if len(config.known_hosts) > 0 {
+		entries = append(entries, archive_entry{name: filepath.Join(home, ".ssh", "known_hosts"), mode: 0644, uid: uid, gid: gid, content: config.known_hosts})
+	}
+	if config.ssh_strict_hosts {
+		// the build user can't change the system wide config, only override it per invocation
+		var ssh_config []byte
+		err = p.step("read_ssh_config", func() (err error) {
+			ssh_config, err = download_image_file(dw, ssh_config_path)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries,
+			archive_entry{name: filepath.Dir(strings.TrimPrefix(ssh_config_path, "/")), dir: true, mode: 0755},
+			archive_entry{name: strings.TrimPrefix(ssh_config_path, "/"), mode: 0644, content: strict_ssh_config(ssh_config)},
+		)
+	}
+	if p.strategy == provisioning_passwd {
+		entries = append(entries,
+			archive_entry{name: "etc/passwd", mode: 0644, content: merge_passwd(p.passwd, config.jenkins_user, uid, gid, jenkins_home_path, config.default_shell)},
+			archive_entry{name: "etc/group", mode: 0644, content: merge_group(p.group, config.jenkins_group, gid, config.jenkins_user, config.jenkins_groups)},
+		)
+	}
+
+	err = p.step("inject_files", func() error {
+		archive, err := build_archive(entries)
+		if err != nil {
+			return err
+		}
+		return dw.UploadArchive("/", archive)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// provision the user in the started container with the tools of the image
+func (p *provisioning) provision_started() error {
+	err := p.provision_user()
+	if err != nil {
+		return err
+	}
+
+	// the shell has to run as the jenkins user
+	return p.step("check_shell", func() error {
+		_, stderr, ret_val, err := p.dw.RunCommand([]string{config.default_shell, "-c", "exit 0"})
+		if err != nil {
+			return err
+		}
+		if ret_val != 0 {
+			return fmt.Errorf("shell '%s' not usable in the image, exit code %d: %s", config.default_shell, ret_val, strings.TrimSpace(stderr))
+		}
+		return nil
+	})
+}
+
+// create the user with the tools of the image
+func (p *provisioning) provision_user() error {
+	jenkins_home_path := config.container_home
+	uid := strconv.Itoa(config.jenkins_uid)
+	gid := strconv.Itoa(config.jenkins_gid)
+
+	switch p.strategy {
+	case provisioning_shadow:
+		err := p.probe("useradd", "userdel", "groupadd", "groupdel")
+		if err != nil {
+			return err
+		}
+		for _, name := range clashing_entries(p.passwd, config.jenkins_user, config.jenkins_uid) {
+			err = p.exec("userdel "+name, "userdel", name)
+			if err != nil {
+				return err
+			}
+		}
+		for _, g := range p.groups() {
+			for _, name := range clashing_entries(p.group, g.name, g.gid) {
+				err = p.exec("groupdel "+name, "groupdel", name)
+				if err != nil {
+					return err
+				}
+			}
+			err = p.exec("groupadd "+g.name, "groupadd", "-g", strconv.Itoa(g.gid), g.name)
+			if err != nil {
+				return err
+			}
+		}
+		command := []string{"useradd", "-d", jenkins_home_path, "-s", config.default_shell, "-g", gid, "-u", uid}
+		if len(config.jenkins_groups) > 0 {
+			command = append(command, "-G", strings.Join(group_names(config.jenkins_groups), ","))
+		}
+		return p.exec("useradd", append(command, config.jenkins_user)...)
+
+	case provisioning_busybox:
+		err := p.probe("adduser", "deluser", "addgroup", "delgroup")
+		if err != nil {
+			return err
+		}
+		for _, name := range clashing_entries(p.passwd, config.jenkins_user, config.jenkins_uid) {
+			err = p.exec("deluser "+name, "deluser", name)
+			if err != nil {
+				return err
+			}
+		}
+		for _, g := range p.groups() {
+			for _, name := range clashing_entries(p.group, g.name, g.gid) {
+				err = p.exec("delgroup "+name, "delgroup", name)
+				if err != nil {
+					return err
+				}
+			}
+			err = p.exec("addgroup "+g.name, "addgroup", "-g", strconv.Itoa(g.gid), g.name)
+			if err != nil {
+				return err
+			}
+		}
+		err = p.exec("adduser", "adduser", "-D", "-H", "-h", jenkins_home_path, "-s", config.default_shell, "-G", config.jenkins_group, "-u", uid, config.jenkins_user)
+		if err != nil {
+			return err
+		}
+		for _, g := range config.jenkins_groups {
+			err = p.exec("addgroup "+config.jenkins_user+" "+g.name, "addgroup", config.jenkins_user, g.name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// nothing left to do for files injected before the start
+	return nil
+}
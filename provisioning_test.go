@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestResolveProvisioning(t *testing.T) {
+	strategy, err := resolve_provisioning("")
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, provisioning_auto, strategy, "Default strategy not auto")
+
+	strategy, err = resolve_provisioning(provisioning_busybox)
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, provisioning_busybox, strategy, "Strategy not kept")
+
+	_, err = resolve_provisioning("ldap")
+	assert.NotEqual(t, nil, err, "Invalid strategy not detected")
+}
+
+func TestDetectProvisioning(t *testing.T) {
+	passwd := []byte("root:x:0:0:root:/root:/bin/bash\njenkins:x:1000:1000::/home/jenkins:/bin/bash\n")
+
+	strategy, err := detect_provisioning(provisioning_auto, passwd, "jenkins", 1000, 1000)
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, provisioning_existing, strategy, "Existing user not detected")
+
+	strategy, err = detect_provisioning(provisioning_auto, passwd, "jenkins", 1001, 1001)
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, provisioning_passwd, strategy, "Different uid has to inject passwd")
+
+	_, err = detect_provisioning(provisioning_existing, passwd, "jenkins", 1001, 1001)
+	assert.NotEqual(t, nil, err, "Missing user not detected")
+	assert.Contains(t, err.Error(), "step 'detect'", "Failed step not reported")
+
+	strategy, err = detect_provisioning(provisioning_shadow, passwd, "jenkins", 1000, 1000)
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, provisioning_shadow, strategy, "Selected strategy not kept")
+}
+
+func TestProvisioningStep(t *testing.T) {
+	p := &provisioning{strategy: provisioning_busybox}
+	err := p.step("adduser", func() error { return errors.New("adduser: not found") })
+	assert.Equal(t, "User provisioning 'busybox' failed at step 'adduser': adduser: not found", err.Error(), "Step not reported")
+	assert.Equal(t, nil, p.step("noop", func() error { return nil }), "Do not return a error")
+}
+
+func TestResolveContainerHome(t *testing.T) {
+	home, err := resolve_container_home("/var/lib/jenkins/")
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "/var/lib/jenkins", home, "Home not cleaned")
+
+	_, err = resolve_container_home("jenkins")
+	assert.NotEqual(t, nil, err, "Relative home not detected")
+	_, err = resolve_container_home("/")
+	assert.NotEqual(t, nil, err, "Root as home not detected")
+	_, err = resolve_container_home("/etc/jenkins")
+	assert.NotEqual(t, nil, err, "Home in /etc not detected")
+	_, err = resolve_container_home("/usr")
+	assert.NotEqual(t, nil, err, "System directory as home not detected")
+}
+
+func TestResolveShell(t *testing.T) {
+	shell, err := resolve_shell("/bin/zsh")
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "/bin/zsh", shell, "Shell not correct")
+
+	_, err = resolve_shell("sh")
+	assert.NotEqual(t, nil, err, "Relative shell not detected")
+	_, err = resolve_shell("/bin/../bin/sh")
+	assert.NotEqual(t, nil, err, "Unclean shell path not detected")
+}
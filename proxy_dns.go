@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// valid search domain of resolv.conf
+var valid_dns_search = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?$`)
+
+// proxy, dns and hosts settings of a build
+type network_settings struct {
+	http_proxy  string
+	https_proxy string
+	no_proxy    []string
+	dns         []string
+	dns_search  []string
+	extra_hosts []string
+}
+
+// settings a job may override and the patterns allowed by the config file
+type network_policy struct {
+	dns         []string // Name servers a job may use
+	dns_search  []string // Patterns of search domains a job may use
+	extra_hosts []string // Patterns of host names a job may add
+	no_proxy    []string // Patterns of hosts a job may exclude from the proxy
+}
+
+// test if a value matches one of the patterns
+func match_any(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, err := filepath.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// check a name server address
+func check_dns(server string) error {
+	if net.ParseIP(server) == nil {
+		return fmt.Errorf("Invalid dns server '%s', expected an ip address", server)
+	}
+	return nil
+}
+
+// check a search domain
+func check_dns_search(domain string) error {
+	if !valid_dns_search.MatchString(domain) {
+		return fmt.Errorf("Invalid dns search domain '%s'", domain)
+	}
+	return nil
+}
+
+// check a host excluded from the proxy, a leading dot excludes the subdomains
+func check_no_proxy(host string) error {
+	if !valid_dns_search.MatchString(strings.TrimPrefix(host, ".")) {
+		return fmt.Errorf("Invalid no_proxy host '%s'", host)
+	}
+	return nil
+}
+
+// split a host:ip entry, the ip may be IPv6
+func split_extra_host(entry string) (string, string, error) {
+	parts := strings.SplitN(entry, ":", 2)
+	if len(parts) != 2 || !valid_dns_search.MatchString(parts[0]) || net.ParseIP(parts[1]) == nil {
+		return "", "", fmt.Errorf("Invalid extra host '%s', expected host:ip", entry)
+	}
+	return parts[0], parts[1], nil
+}
+
+// check the settings of the config file
+func check_network_settings(s *network_settings) error {
+	for _, server := range s.dns {
+		err := check_dns(server)
+		if err != nil {
+			return err
+		}
+	}
+	for _, domain := range s.dns_search {
+		err := check_dns_search(domain)
+		if err != nil {
+			return err
+		}
+	}
+	for _, entry := range s.extra_hosts {
+		_, _, err := split_extra_host(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check a setting of a job against the policy
+func check_job_network_value(check string, value string, err error, allowed bool) error {
+	if err == nil && !allowed {
+		err = fmt.Errorf("%s '%s' of the job is not allowed by the config file", check, value)
+	}
+	audit_policy(check, value, err)
+	return err
+}
+
+// settings of the config file with the overrides of a job, dns servers and search domains
+// of a job replace the ones of the config file, hosts and proxy exclusions are added
+func resolve_network_settings(base network_settings, policy network_policy, pc *ProjektConf) (network_settings, error) {
+	s := base
+	if len(pc.Dns) > 0 {
+		for _, server := range pc.Dns {
+			err := check_job_network_value("dns", server, check_dns(server), string_in_slice(server, policy.dns))
+			if err != nil {
+				return s, err
+			}
+		}
+		s.dns = pc.Dns
+	}
+	if len(pc.DnsSearch) > 0 {
+		for _, domain := range pc.DnsSearch {
+			err := check_job_network_value("dns_search", domain, check_dns_search(domain), match_any(policy.dns_search, domain))
+			if err != nil {
+				return s, err
+			}
+		}
+		s.dns_search = pc.DnsSearch
+	}
+	for _, entry := range pc.ExtraHosts {
+		host, _, err := split_extra_host(entry)
+		err = check_job_network_value("extra_host", entry, err, match_any(policy.extra_hosts, host))
+		if err != nil {
+			return s, err
+		}
+	}
+	s.extra_hosts = append(append([]string{}, base.extra_hosts...), pc.ExtraHosts...)
+	for _, host := range pc.NoProxy {
+		err := check_job_network_value("no_proxy", host, check_no_proxy(host), match_any(policy.no_proxy, host))
+		if err != nil {
+			return s, err
+		}
+	}
+	s.no_proxy = append(append([]string{}, base.no_proxy...), pc.NoProxy...)
+	return s, nil
+}
+
+// configured proxy variables in upper and lower case, they replace inherited ones
+func (s network_settings) proxy_environment() []string {
+	env := []string{}
+	values := [][2]string{
+		{"http_proxy", s.http_proxy},
+		{"https_proxy", s.https_proxy},
+		{"no_proxy", strings.Join(s.no_proxy, ",")},
+	}
+	for _, v := range values {
+		if v[1] == "" {
+			continue
+		}
+		env = append(env,
+			fmt.Sprintf("%s=%s", v[0], v[1]),
+			fmt.Sprintf("%s=%s", strings.ToUpper(v[0]), v[1]),
+		)
+	}
+	return env
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCheckNetworkSettings(t *testing.T) {
+	s := &network_settings{
+		dns:         []string{"10.0.0.53", "fd00::53"},
+		dns_search:  []string{"corp.example.com"},
+		extra_hosts: []string{"git.corp.example.com:10.0.0.10", "ipv6.corp.example.com:fd00::10"},
+	}
+	assert.Equal(t, nil, check_network_settings(s), "Do not return a error")
+
+	assert.NotEqual(t, nil, check_network_settings(&network_settings{dns: []string{"ns.example.com"}}), "Dns server without ip accepted")
+	assert.NotEqual(t, nil, check_network_settings(&network_settings{dns_search: []string{"bad domain"}}), "Invalid search domain accepted")
+	assert.NotEqual(t, nil, check_network_settings(&network_settings{extra_hosts: []string{"git.corp.example.com"}}), "Extra host without ip accepted")
+}
+
+func TestResolveNetworkSettings(t *testing.T) {
+	base := network_settings{
+		http_proxy:  "http://proxy.corp.example.com:3128",
+		https_proxy: "http://proxy.corp.example.com:3128",
+		no_proxy:    []string{"localhost", ".corp.example.com"},
+		dns:         []string{"10.0.0.53"},
+		extra_hosts: []string{"git.corp.example.com:10.0.0.10"},
+	}
+	policy := network_policy{
+		dns:         []string{"10.1.0.53"},
+		dns_search:  []string{"*.corp.example.com"},
+		extra_hosts: []string{"*.test"},
+		no_proxy:    []string{"*.internal"},
+	}
+
+	// the config file applies without overrides
+	s, err := resolve_network_settings(base, policy, &ProjektConf{})
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, base, s, "Settings of the config file changed")
+
+	pc := &ProjektConf{
+		Dns:        []string{"10.1.0.53"},
+		DnsSearch:  []string{"team.corp.example.com"},
+		ExtraHosts: []string{"db.test:127.0.0.1"},
+		NoProxy:    []string{"registry.internal"},
+	}
+	s, err = resolve_network_settings(base, policy, pc)
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, []string{"10.1.0.53"}, s.dns, "Dns of the job not used")
+	assert.Equal(t, []string{"team.corp.example.com"}, s.dns_search, "Search domain of the job not used")
+	assert.Equal(t, []string{"git.corp.example.com:10.0.0.10", "db.test:127.0.0.1"}, s.extra_hosts, "Extra hosts not added")
+	assert.Equal(t, []string{"localhost", ".corp.example.com", "registry.internal"}, s.no_proxy, "No proxy not added")
+	assert.Equal(t, []string{"git.corp.example.com:10.0.0.10"}, base.extra_hosts, "Settings of the config file modified")
+
+	_, err = resolve_network_settings(base, policy, &ProjektConf{Dns: []string{"8.8.8.8"}})
+	assert.NotEqual(t, nil, err, "Dns server outside of the policy accepted")
+
+	_, err = resolve_network_settings(base, policy, &ProjektConf{ExtraHosts: []string{"git.corp.example.com:6.6.6.6"}})
+	assert.NotEqual(t, nil, err, "Extra host outside of the policy accepted")
+
+	_, err = resolve_network_settings(base, policy, &ProjektConf{NoProxy: []string{"*"}})
+	assert.NotEqual(t, nil, err, "No proxy outside of the policy accepted")
+
+	// patterns match commas and spaces, the host names have to be checked first
+	_, err = resolve_network_settings(base, policy, &ProjektConf{NoProxy: []string{".team.internal"}})
+	assert.Equal(t, nil, err, "Do not return a error")
+	_, err = resolve_network_settings(base, policy, &ProjektConf{NoProxy: []string{"*,foo.internal"}})
+	assert.NotEqual(t, nil, err, "No proxy with a comma accepted")
+	_, err = resolve_network_settings(base, policy, &ProjektConf{ExtraHosts: []string{"github.com x.test:1.2.3.4"}})
+	assert.NotEqual(t, nil, err, "Extra host with a space accepted")
+
+	_, err = resolve_network_settings(base, network_policy{}, &ProjektConf{DnsSearch: []string{"corp.example.com"}})
+	assert.NotEqual(t, nil, err, "Override accepted without policy")
+}
+
+func TestProxyEnvironment(t *testing.T) {
+	assert.Equal(t, []string{}, network_settings{}.proxy_environment(), "Proxy variables without proxy")
+
+	s := network_settings{http_proxy: "http://proxy:3128"}
+	assert.Equal(
+		t,
+		[]string{"http_proxy=http://proxy:3128", "HTTP_PROXY=http://proxy:3128"},
+		s.proxy_environment(),
+		"Unset proxy variables emitted",
+	)
+
+	s = network_settings{http_proxy: "http://proxy:3128", https_proxy: "http://proxy:3128", no_proxy: []string{"localhost", ".corp"}}
+	assert.Equal(
+		t,
+		[]string{
+			"http_proxy=http://proxy:3128",
+			"HTTP_PROXY=http://proxy:3128",
+			"https_proxy=http://proxy:3128",
+			"HTTPS_PROXY=http://proxy:3128",
+			"no_proxy=localhost,.corp",
+			"NO_PROXY=localhost,.corp",
+		},
+		s.proxy_environment(),
+		"Proxy variables not correct",
+	)
+}
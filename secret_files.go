@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tmpfs in the container holding the secret files, gone with the container
+const secret_files_dir = "/run/jenkins_docker_wrapper_secrets"
+
+// size of the secret files tmpfs
+const secret_files_tmpfs_size = "16m"
+
+// upper limit of a single secret file
+const secret_file_max_size = 1024 * 1024
+
+// host file delivered into the container
+type secret_file struct {
+	host      string // Path on the host
+	container string // Path in the container
+	content   []byte
+}
+
+// parse a host_path:container_path mapping, relative container paths are below the secret files dir
+func parse_secret_file_arg(arg string) (secret_file, error) {
+	parts := strings.SplitN(arg, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return secret_file{}, fmt.Errorf("Invalid secret file '%s', expected host_path:container_path", arg)
+	}
+	if !filepath.IsAbs(parts[0]) {
+		return secret_file{}, fmt.Errorf("Invalid secret file '%s', expected absolute host path", arg)
+	}
+	container := parts[1]
+	if !filepath.IsAbs(container) {
+		container = filepath.Join(secret_files_dir, container)
+	}
+	container = filepath.Clean(container)
+	if container == secret_files_dir || container == "/" {
+		return secret_file{}, fmt.Errorf("Invalid secret file '%s', expected a file as container path", arg)
+	}
+	return secret_file{host: filepath.Clean(parts[0]), container: container}, nil
+}
+
+// read a secret file of the caller
+func read_secret_file(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("Secret file %s is not a regular file", path)
+	}
+	if info.Size() > secret_file_max_size {
+		return nil, fmt.Errorf("Secret file %s is larger than %d bytes", path, secret_file_max_size)
+	}
+	return ioutil.ReadAll(io.LimitReader(file, secret_file_max_size))
+}
+
+// parse the secret file mappings and read the files with the credentials of the caller
+func resolve_secret_files(mappings []string) ([]secret_file, error) {
+	files := []secret_file{}
+	seen := map[string]bool{}
+	for _, mapping := range mappings {
+		f, err := parse_secret_file_arg(mapping)
+		if err == nil && seen[f.container] {
+			err = fmt.Errorf("Secret file '%s' maps to %s twice", mapping, f.container)
+		}
+		if err == nil {
+			err = as_caller(func() (err error) {
+				f.content, err = read_secret_file(f.host)
+				return err
+			})
+		}
+		audit_policy("secret_file", f.host, err)
+		if err != nil {
+			return nil, err
+		}
+		seen[f.container] = true
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// tmpfs mount of the secret files, only the build user can enter it
+func secret_files_tmpfs(uid int, gid int) map[string]string {
+	return map[string]string{
+		secret_files_dir: fmt.Sprintf("rw,noexec,nosuid,nodev,size=%s,mode=0700,uid=%d,gid=%d", secret_files_tmpfs_size, uid, gid),
+	}
+}
+
+// path of a secret file in the tmpfs, files outside of it are symlinked
+func secret_file_storage(container string) string {
+	if path_within(secret_files_dir, container) {
+		return container
+	}
+	return filepath.Join(secret_files_dir, "files", container)
+}
+
+// write the secret files into the tmpfs of the started container as the build user
+func deliver_secret_files(dw *docker_wrapper.DockerWrapper, files []secret_file) error {
+	for _, f := range files {
+		storage := secret_file_storage(f.container)
+		command := []string{"sh", "-c", `umask 077 && mkdir -p "$(dirname "$1")" && cat > "$1"`, "sh", storage}
+		_, stderr, ret_val, err := dw.RunCommandInput(command, "", bytes.NewReader(f.content))
+		if err == nil && ret_val != 0 {
+			err = errors.New(strings.TrimSpace(stderr))
+		}
+		if err == nil && storage != f.container {
+			command = []string{"sh", "-c", `mkdir -p "$(dirname "$2")" && ln -sfn "$1" "$2"`, "sh", storage, f.container}
+			_, stderr, ret_val, err = dw.RunCommand(command)
+			if err == nil && ret_val != 0 {
+				err = errors.New(strings.TrimSpace(stderr))
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("Can't deliver secret file to %s: %s", f.container, err)
+		}
+		log.Debugf("Delivered secret file %s to %s", f.host, f.container)
+	}
+	return nil
+}
+
+// remove the secret files and their symlinks before the container is stopped or kept
+func wipe_secret_files(dw *docker_wrapper.DockerWrapper, files []secret_file) {
+	if len(files) == 0 {
+		return
+	}
+	command := []string{"rm", "-rf", "--"}
+	for _, f := range files {
+		command = append(command, secret_file_storage(f.container))
+		if secret_file_storage(f.container) != f.container {
+			command = append(command, f.container)
+		}
+	}
+	_, stderr, ret_val, err := dw.RunCommand(command)
+	if err == nil && ret_val != 0 {
+		err = errors.New(strings.TrimSpace(stderr))
+	}
+	if err != nil {
+		log.Warnf("Can't wipe secret files: %s", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSecretFileArg(t *testing.T) {
+	f, err := parse_secret_file_arg("/var/lib/jenkins/workspace/job@tmp/secretFiles/1234/kubeconfig:/home/jenkins/.kube/config")
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "/var/lib/jenkins/workspace/job@tmp/secretFiles/1234/kubeconfig", f.host, "Host path not correct")
+	assert.Equal(t, "/home/jenkins/.kube/config", f.container, "Container path not correct")
+
+	f, err = parse_secret_file_arg("/tmp/npmrc:npmrc")
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, secret_files_dir+"/npmrc", f.container, "Relative path not below the secret files dir")
+
+	_, err = parse_secret_file_arg("relative:/home/jenkins/.npmrc")
+	assert.NotEqual(t, nil, err, "Relative host path accepted")
+
+	_, err = parse_secret_file_arg("/tmp/npmrc")
+	assert.NotEqual(t, nil, err, "Missing container path accepted")
+
+	_, err = parse_secret_file_arg("/tmp/npmrc:" + secret_files_dir)
+	assert.NotEqual(t, nil, err, "Secret files dir accepted as file")
+}
+
+func TestSecretFileStorage(t *testing.T) {
+	assert.Equal(t, secret_files_dir+"/npmrc", secret_file_storage(secret_files_dir+"/npmrc"), "File in the tmpfs moved")
+	assert.Equal(t, secret_files_dir+"/files/home/jenkins/.npmrc", secret_file_storage("/home/jenkins/.npmrc"), "File outside of the tmpfs not stored in it")
+
+	options := secret_files_tmpfs(1000, 1001)
+	assert.Equal(t, "rw,noexec,nosuid,nodev,size=16m,mode=0700,uid=1000,gid=1001", options[secret_files_dir], "Tmpfs options not correct")
+}
+
+func TestResolveSecretFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "token")
+	ioutil.WriteFile(path, []byte("s3cr3t"), 0600)
+
+	files, err := resolve_secret_files([]string{fmt.Sprintf("%s:token", path)})
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, []byte("s3cr3t"), files[0].content, "Content not read")
+
+	_, err = resolve_secret_files([]string{fmt.Sprintf("%s:token", path), fmt.Sprintf("%s:token", path)})
+	assert.NotEqual(t, nil, err, "Same container path accepted twice")
+
+	_, err = resolve_secret_files([]string{fmt.Sprintf("%s:token", dir)})
+	assert.NotEqual(t, nil, err, "Directory accepted as secret file")
+
+	_, err = resolve_secret_files([]string{fmt.Sprintf("%s:token", filepath.Join(dir, "missing"))})
+	assert.NotEqual(t, nil, err, "Missing file accepted")
+}
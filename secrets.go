@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// env names whose values are treated as secrets by default
+var default_secret_patterns = []string{"*_TOKEN", "*_PASSWORD", "*_SECRET"}
+
+// replacement for secret values
+const secret_mask = "********"
+
+// shorter values are not masked, they would garble the whole output
+const secret_min_length = 4
+
+type by_length_desc []string
+
+func (s by_length_desc) Len() int           { return len(s) }
+func (s by_length_desc) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s by_length_desc) Less(i, j int) bool { return len(s[i]) > len(s[j]) }
+
+type secret_masker struct {
+	mutex    sync.RWMutex
+	secrets  []string
+	replacer *strings.Replacer
+}
+
+// secrets of this invocation
+var secrets secret_masker
+
+// register a secret value
+func (m *secret_masker) add(value string) {
+	if len(value) < secret_min_length {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, secret := range m.secrets {
+		if secret == value {
+			return
+		}
+	}
+	m.secrets = append(m.secrets, value)
+
+	// replace longer secrets first
+	sort.Sort(by_length_desc(m.secrets))
+	pairs := []string{}
+	for _, secret := range m.secrets {
+		pairs = append(pairs, secret, secret_mask)
+	}
+	m.replacer = strings.NewReplacer(pairs...)
+}
+
+// redact all known secret values
+func (m *secret_masker) mask(s string) string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.replacer == nil {
+		return s
+	}
+	return m.replacer.Replace(s)
+}
+
+// redact all known secret values of a list into a new list
+func (m *secret_masker) mask_list(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	masked := make([]string, len(values))
+	for i, value := range values {
+		masked[i] = m.mask(value)
+	}
+	return masked
+}
+
+// test if an env name matches one of the secret patterns
+func is_secret_key(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if matched, err := filepath.Match(pattern, key); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// register the values of all secret env vars
+func collect_secrets_env(patterns []string, env []string) {
+	for _, env_elem := range env {
+		env_split := strings.SplitN(env_elem, "=", 2)
+		if len(env_split) != 2 {
+			continue
+		}
+		if is_secret_key(patterns, env_split[0]) {
+			secrets.add(env_split[1])
+		}
+	}
+}
+
+// register every line of r as secret value
+func collect_secrets_io(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		secrets.add(strings.TrimRight(scanner.Text(), "\r"))
+	}
+	return scanner.Err()
+}
+
+// register every line of a secrets file as secret value
+func collect_secrets_file(path string) error {
+	// the file has to be readable by the calling user, not only by us
+	err := syscall.Access(path, 4)
+	if err != nil {
+		return fmt.Errorf("Secrets file '%s' is not readable: %s", path, err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return collect_secrets_io(file)
+}
+
+// logrus formatter that redacts secrets
+type masking_formatter struct {
+	formatter log.Formatter
+}
+
+func (f *masking_formatter) Format(entry *log.Entry) ([]byte, error) {
+	// mask before formatting, json and quoted text values escape secrets
+	masked := *entry
+	masked.Message = secrets.mask(entry.Message)
+	masked.Data = log.Fields{}
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			value = secrets.mask(v)
+		case error:
+			value = secrets.mask(v.Error())
+		}
+		masked.Data[key] = value
+	}
+
+	b, err := f.formatter.Format(&masked)
+	if err != nil {
+		return b, err
+	}
+	return []byte(secrets.mask(string(b))), nil
+}
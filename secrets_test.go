@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSecretKeyPatterns(t *testing.T) {
+	assert.Equal(t, true, is_secret_key(default_secret_patterns, "GITHUB_TOKEN"), "Token not detected")
+	assert.Equal(t, true, is_secret_key(default_secret_patterns, "DB_PASSWORD"), "Password not detected")
+	assert.Equal(t, false, is_secret_key(default_secret_patterns, "WORKSPACE"), "Workspace detected as secret")
+	assert.Equal(t, false, is_secret_key(default_secret_patterns, "TOKEN_FILE"), "Suffix pattern matches prefix")
+}
+
+func TestSecretMasking(t *testing.T) {
+	secrets = secret_masker{}
+
+	assert.Equal(t, "nothing to hide", secrets.mask("nothing to hide"), "Output changed without secrets")
+
+	collect_secrets_env(default_secret_patterns, []string{
+		"GITHUB_TOKEN=abcdef123456",
+		"SHORT_TOKEN=ab",
+		"USER=jenkins",
+	})
+	err := collect_secrets_io(bytes.NewBufferString("s3cr3t\nabcdef123456789\n"))
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	assert.Equal(t, "token=********", secrets.mask("token=abcdef123456"), "Env secret not masked")
+	assert.Equal(t, "pw ********", secrets.mask("pw s3cr3t"), "File secret not masked")
+	assert.Equal(t, "long ********", secrets.mask("long abcdef123456789"), "Longer secret not masked first")
+	assert.Equal(t, "user ab jenkins", secrets.mask("user ab jenkins"), "Short or non secret values masked")
+}
+
+func TestSecretMaskingFormatter(t *testing.T) {
+	secrets = secret_masker{}
+	secrets.add("hunter22")
+
+	f := &masking_formatter{formatter: &log.TextFormatter{DisableColors: true}}
+	b, err := f.Format(log.WithField("password", "hunter22"))
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.NotContains(t, string(b), "hunter22", "Secret not masked in log entry")
+}
+
+func TestSecretMaskingFormatterJson(t *testing.T) {
+	secrets = secret_masker{}
+	secrets.add(`pa&ss"<word>`)
+
+	f := &masking_formatter{formatter: &log.JSONFormatter{}}
+	entry := log.WithField("password", `pa&ss"<word>`)
+	entry.Message = `login with pa&ss"<word>`
+	b, err := f.Format(entry)
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	var fields map[string]string
+	err = json.Unmarshal(b, &fields)
+	assert.Equal(t, nil, err, "Log entry is not valid JSON")
+	assert.Equal(t, secret_mask, fields["password"], "Secret not masked in field")
+	assert.Equal(t, "login with "+secret_mask, fields["msg"], "Secret not masked in message")
+	assert.Equal(t, `pa&ss"<word>`, entry.Data["password"], "Fields of the entry modified")
+}
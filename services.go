@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// label of sidecar service containers
+const label_service = label_prefix + "service"
+
+const default_service_ready_timeout = "60s"
+
+const default_service_ready_interval = "1s"
+
+var valid_service_name = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.-]*$")
+
+// readiness check of a sidecar service
+type ServiceReadyConf struct {
+	Command  []string `json:"command"`
+	Timeout  string   `json:"timeout"`
+	Interval string   `json:"interval"`
+	timeout  time.Duration
+	interval time.Duration
+}
+
+// sidecar service started next to the build container
+type ServiceConf struct {
+	Name        string            `json:"name"`
+	Image       string            `json:"image"`
+	Environment map[string]string `json:"environment"`
+	Aliases     []string          `json:"aliases"`
+	Ready       ServiceReadyConf  `json:"ready"`
+}
+
+// network of a build and its sidecar services
+type build_network struct {
+	sync.Mutex
+	dw       *docker_wrapper.DockerWrapper
+	id       string
+	name     string
+	services []*docker_wrapper.DockerWrapper
+}
+
+// validate the services of projekt.conf and apply defaults
+func resolve_services(services []ServiceConf) ([]ServiceConf, error) {
+	resolved := []ServiceConf{}
+	names := map[string]bool{}
+	for _, service := range services {
+		if !valid_service_name.MatchString(service.Name) {
+			return nil, fmt.Errorf("Invalid service name '%s'", service.Name)
+		}
+		if names[service.Name] {
+			return nil, fmt.Errorf("Duplicate service name '%s'", service.Name)
+		}
+		names[service.Name] = true
+
+		if service.Image == "" {
+			return nil, fmt.Errorf("No image given for service '%s'", service.Name)
+		}
+		err := check_image_allowed(service.Image)
+		if err != nil {
+			return nil, err
+		}
+
+		service.Ready.timeout, err = time.ParseDuration(first_non_empty(service.Ready.Timeout, default_service_ready_timeout))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ready timeout of service '%s': %s", service.Name, err)
+		}
+		service.Ready.interval, err = time.ParseDuration(first_non_empty(service.Ready.Interval, default_service_ready_interval))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ready interval of service '%s': %s", service.Name, err)
+		}
+		if service.Ready.interval <= 0 {
+			return nil, fmt.Errorf("Invalid ready interval of service '%s': has to be positive", service.Name)
+		}
+
+		resolved = append(resolved, service)
+	}
+	return resolved, nil
+}
+
+// environment of a service in docker format, sorted by key
+func service_environment(env map[string]string) []string {
+	keys := []string{}
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := []string{}
+	for _, key := range keys {
+		result = append(result, fmt.Sprintf("%s=%s", key, env[key]))
+	}
+	return result
+}
+
+// host names of a service in the build network
+func service_aliases(service ServiceConf) []string {
+	return append([]string{service.Name}, service.Aliases...)
+}
+
+// random name of a build network
+func build_network_name() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "jenkins_docker_wrapper_" + hex.EncodeToString(b), nil
+}
+
+// configure the container of a sidecar service
+func configure_service_wrapper(dw *docker_wrapper.DockerWrapper, service ServiceConf, network string) {
+	// run the default command of the image
+	dw.DefaultRunCmd = nil
+	dw.ImageName = service.Image
+	dw.Environment = service_environment(service.Environment)
+	dw.Labels = container_labels(os.Getuid(), service.Image)
+	dw.Labels[label_service] = service.Name
+	dw.NetworkMode = network
+	dw.NetworkAliases = service_aliases(service)
+}
+
+// wait until the service is running and its ready command succeeds
+func wait_service_ready(dw *docker_wrapper.DockerWrapper, service ServiceConf) error {
+	deadline := time.Now().Add(service.Ready.timeout)
+	for {
+		container, err := dw.Inspect()
+		if err != nil {
+			return err
+		}
+		if !container.State.Running {
+			return fmt.Errorf("Service '%s' exited with code %d", service.Name, container.State.ExitCode)
+		}
+		if len(service.Ready.Command) == 0 {
+			return nil
+		}
+
+		_, _, ret_val, err := run_command(dw, service.Ready.Command)
+		if err == nil && ret_val == 0 {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Service '%s' not ready after %s", service.Name, service.Ready.timeout)
+		}
+		time.Sleep(service.Ready.interval)
+	}
+}
+
+// create the build network and start the sidecar services in it
+func start_services(image string, services []ServiceConf) (*build_network, error) {
+	if len(services) == 0 {
+		return nil, nil
+	}
+
+	dw, err := docker_wrapper.New()
+	if err != nil {
+		return nil, err
+	}
+	name, err := build_network_name()
+	if err != nil {
+		return nil, err
+	}
+	network, err := dw.CreateNetwork(name, container_labels(os.Getuid(), image))
+	if err != nil {
+		return nil, fmt.Errorf("Can't create build network: %s", err)
+	}
+	n := &build_network{
+		dw:       dw,
+		id:       network.ID,
+		name:     name,
+		services: []*docker_wrapper.DockerWrapper{},
+	}
+	log.Debugf("Created build network %s", name)
+
+	// an abort while waiting for the services must not leak them
+	teardown := add_teardown(func() { n.stop(nil) })
+	defer drop_teardown(teardown)
+	for _, service := range services {
+		err := n.start_service(image, service)
+		if err != nil {
+			run_teardown(teardown)
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// start a single sidecar service and wait for it
+func (n *build_network) start_service(image string, service ServiceConf) error {
+	sdw, err := docker_wrapper.New()
+	if err != nil {
+		return err
+	}
+	configure_service_wrapper(sdw, service, n.name)
+
+	phase := time.Now()
+	err = sdw.Run()
+	if sdw.ContainerID() != "" {
+		n.Lock()
+		n.services = append(n.services, sdw)
+		n.Unlock()
+	}
+	if err != nil {
+		return fmt.Errorf("Can't start service '%s': %s", service.Name, err)
+	}
+	audit_record_container(sdw)
+
+	log.Infof("Started service '%s' (%s), waiting until it is ready", service.Name, service.Image)
+	err = wait_service_ready(sdw, service)
+	audit_phase(build_phase_name(image, "service_"+service.Name), phase)
+	return err
+}
+
+// stop the services and remove the network, the build container gets disconnected
+func (n *build_network) stop(build *docker_wrapper.DockerWrapper) {
+	if n == nil {
+		return
+	}
+
+	n.Lock()
+	services := n.services
+	n.Unlock()
+	for _, sdw := range services {
+		remove_container(sdw)
+	}
+
+	// a kept build container must not block removing the network
+	if build != nil && build.ContainerID() != "" {
+		err := build.DisconnectNetwork(n.id)
+		if err != nil {
+			log.Debugf("Can't disconnect build container from network %s: %s", n.name, err)
+		}
+	}
+
+	err := n.dw.RemoveNetwork(n.id)
+	if err != nil {
+		log.Warnf("Can't remove build network %s: %s", n.name, err)
+	}
+}
+
+// network mode of the build container
+func (n *build_network) network_mode() string {
+	if n == nil {
+		return ""
+	}
+	return n.name
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveServices(t *testing.T) {
+	config.allowed_images = nil
+
+	services, err := resolve_services([]ServiceConf{
+		{Name: "db", Image: "postgres:9.5", Ready: ServiceReadyConf{Timeout: "30s"}},
+	})
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, 30*time.Second, services[0].Ready.timeout, "Timeout not parsed")
+	assert.Equal(t, time.Second, services[0].Ready.interval, "Default interval not set")
+
+	_, err = resolve_services([]ServiceConf{{Name: "db", Image: "postgres"}, {Name: "db", Image: "redis"}})
+	assert.NotEqual(t, nil, err, "Duplicate service name not detected")
+
+	_, err = resolve_services([]ServiceConf{{Name: "../db", Image: "postgres"}})
+	assert.NotEqual(t, nil, err, "Invalid service name not detected")
+
+	_, err = resolve_services([]ServiceConf{{Name: "db"}})
+	assert.NotEqual(t, nil, err, "Missing image not detected")
+
+	_, err = resolve_services([]ServiceConf{{Name: "db", Image: "postgres", Ready: ServiceReadyConf{Timeout: "soon"}}})
+	assert.NotEqual(t, nil, err, "Invalid timeout not detected")
+
+	config.allowed_images, _ = compile_image_patterns([]string{"debian:.*"})
+	_, err = resolve_services([]ServiceConf{{Name: "db", Image: "postgres"}})
+	assert.NotEqual(t, nil, err, "Image allowlist not applied to services")
+	config.allowed_images = nil
+}
+
+func TestServiceEnvironment(t *testing.T) {
+	env := service_environment(map[string]string{"POSTGRES_USER": "jenkins", "POSTGRES_DB": "test"})
+	assert.Equal(t, []string{"POSTGRES_DB=test", "POSTGRES_USER=jenkins"}, env, "Environment not sorted")
+
+	aliases := service_aliases(ServiceConf{Name: "db", Aliases: []string{"postgres"}})
+	assert.Equal(t, []string{"db", "postgres"}, aliases, "Service name missing in aliases")
+}
+
+func TestBuildNetworkName(t *testing.T) {
+	name1, err := build_network_name()
+	assert.Equal(t, nil, err, "Do not return a error")
+	name2, _ := build_network_name()
+	assert.True(t, strings.HasPrefix(name1, "jenkins_docker_wrapper_"), "Network name prefix missing")
+	assert.NotEqual(t, name1, name2, "Network names have to be unique")
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// private directory of the forwarded agent socket in the container tmp dir
+const ssh_agent_dir_name = "ssh_agent"
+
+// name of the forwarded agent socket
+const ssh_agent_socket_name = "ssh_agent.sock"
+
+// messages of the ssh agent protocol
+const (
+	ssh_agent_failure             = 5
+	ssh_agentc_request_identities = 11
+	ssh_agent_identities_answer   = 12
+	ssh_agentc_sign_request       = 13
+)
+
+// upper limit of an agent message
+const ssh_agent_max_message = 256 * 1024
+
+// per build socket forwarding to the agent of the caller
+type ssh_agent_proxy struct {
+	upstream string   // Agent socket of the caller
+	keys     []string // Fingerprints of the keys usable in the build, empty allows all
+	dir      string   // Private directory of the socket
+	listener net.Listener
+}
+
+// path of the forwarded agent socket in a tmp dir
+func ssh_agent_socket_path(tmp_dir string) string {
+	return filepath.Join(tmp_dir, ssh_agent_dir_name, ssh_agent_socket_name)
+}
+
+// SHA256 fingerprint of a public key blob as printed by ssh-add -l
+func ssh_key_fingerprint(blob []byte) string {
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// keys usable in the build, a job can only restrict the keys of the config file
+func resolve_ssh_agent_keys(policy []string, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return policy, nil
+	}
+	if len(policy) > 0 {
+		for _, key := range requested {
+			if !string_in_slice(key, policy) {
+				err := fmt.Errorf("SSH key '%s' is not in ssh_agent_keys", key)
+				audit_policy("ssh_agent_key", key, err)
+				return nil, err
+			}
+		}
+	}
+	return requested, nil
+}
+
+// read a length prefixed agent message
+func read_agent_message(r io.Reader) ([]byte, error) {
+	var length uint32
+	err := binary.Read(r, binary.BigEndian, &length)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 || length > ssh_agent_max_message {
+		return nil, fmt.Errorf("Invalid ssh agent message length %d", length)
+	}
+	msg := make([]byte, length)
+	_, err = io.ReadFull(r, msg)
+	return msg, err
+}
+
+// write a length prefixed agent message
+func write_agent_message(w io.Writer, msg []byte) error {
+	err := binary.Write(w, binary.BigEndian, uint32(len(msg)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	return err
+}
+
+// split a length prefixed string off an agent message
+func read_agent_string(b []byte) ([]byte, []byte, error) {
+	if len(b) < 4 {
+		return nil, nil, errors.New("Short ssh agent message")
+	}
+	length := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < length {
+		return nil, nil, errors.New("Short ssh agent message")
+	}
+	return b[4 : 4+length], b[4+length:], nil
+}
+
+// append a length prefixed string to an agent message
+func append_agent_string(b []byte, s []byte) []byte {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(s)))
+	return append(append(b, length...), s...)
+}
+
+// test if a key blob is usable in the build
+func (p *ssh_agent_proxy) key_allowed(blob []byte) bool {
+	return len(p.keys) == 0 || string_in_slice(ssh_key_fingerprint(blob), p.keys)
+}
+
+// remove the keys not usable in the build from an identities answer
+func (p *ssh_agent_proxy) filter_identities(msg []byte) ([]byte, error) {
+	if len(msg) < 5 || msg[0] != ssh_agent_identities_answer {
+		return msg, nil
+	}
+	count := binary.BigEndian.Uint32(msg[1:])
+	rest := msg[5:]
+
+	keys := []byte{}
+	var allowed uint32
+	for i := uint32(0); i < count; i++ {
+		blob, r, err := read_agent_string(rest)
+		if err != nil {
+			return nil, err
+		}
+		comment, r, err := read_agent_string(r)
+		if err != nil {
+			return nil, err
+		}
+		rest = r
+		if p.key_allowed(blob) {
+			keys = append_agent_string(append_agent_string(keys, blob), comment)
+			allowed++
+		}
+	}
+
+	answer := []byte{ssh_agent_identities_answer, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(answer[1:], allowed)
+	return append(answer, keys...), nil
+}
+
+// answer a request of the build, only listing keys and signing with allowed keys is forwarded
+func (p *ssh_agent_proxy) handle(req []byte, upstream io.ReadWriter) ([]byte, error) {
+	failure := []byte{ssh_agent_failure}
+	switch req[0] {
+	case ssh_agentc_request_identities:
+	case ssh_agentc_sign_request:
+		blob, _, err := read_agent_string(req[1:])
+		if err != nil || !p.key_allowed(blob) {
+			return failure, nil
+		}
+	default:
+		return failure, nil
+	}
+
+	err := write_agent_message(upstream, req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := read_agent_message(upstream)
+	if err != nil {
+		return nil, err
+	}
+	return p.filter_identities(resp)
+}
+
+// forward a connection of the build to the agent of the caller
+func (p *ssh_agent_proxy) serve(client net.Conn) {
+	defer client.Close()
+
+	// connect with the credentials of the caller, net.Dial of a unix socket stays on this thread
+	var upstream net.Conn
+	err := as_caller(func() (err error) {
+		upstream, err = net.Dial("unix", p.upstream)
+		return err
+	})
+	if err != nil {
+		log.Warnf("Can't connect to ssh agent: %s", err)
+		return
+	}
+	defer upstream.Close()
+
+	if len(p.keys) == 0 {
+		go func() {
+			io.Copy(upstream, client)
+			upstream.Close()
+		}()
+		io.Copy(client, upstream)
+		return
+	}
+
+	for {
+		req, err := read_agent_message(client)
+		if err != nil {
+			return
+		}
+		resp, err := p.handle(req, upstream)
+		if err != nil {
+			log.Warnf("Can't forward ssh agent request: %s", err)
+			return
+		}
+		err = write_agent_message(client, resp)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// accept connections of the build until the proxy is closed
+func (p *ssh_agent_proxy) accept() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.serve(conn)
+	}
+}
+
+// remove the socket of the build, the agent of the caller stays untouched
+func (p *ssh_agent_proxy) close() {
+	if p == nil {
+		return
+	}
+	p.listener.Close()
+	os.Remove(p.dir)
+}
+
+// open a socket in dir usable only by uid forwarding to the agent socket of the caller
+func start_ssh_agent_proxy(upstream string, keys []string, dir string, uid int, gid int) (*ssh_agent_proxy, error) {
+	err := as_caller(func() error {
+		info, err := os.Stat(upstream)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("SSH_AUTH_SOCK '%s' is not a socket", upstream)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// no other user may connect between listen and chown, the process umask is shared
+	// with other goroutines, the socket is created in a private directory instead
+	socket_dir := filepath.Join(dir, ssh_agent_dir_name)
+	err = os.Mkdir(socket_dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	path := ssh_agent_socket_path(dir)
+	listener, err := net.Listen("unix", path)
+	if err == nil {
+		err = os.Chmod(path, 0600)
+	}
+	if err == nil {
+		err = os.Chown(path, uid, gid)
+	}
+	if err == nil {
+		err = os.Chown(socket_dir, uid, gid)
+	}
+	if err != nil {
+		if listener != nil {
+			listener.Close()
+		}
+		os.Remove(socket_dir)
+		return nil, err
+	}
+
+	p := &ssh_agent_proxy{
+		upstream: upstream,
+		keys:     keys,
+		dir:      socket_dir,
+		listener: listener,
+	}
+	go p.accept()
+	log.Debugf("Forward ssh agent %s through %s", upstream, path)
+	return p, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// agent answering with two keys and signing everything
+func fake_ssh_agent(t *testing.T, path string, blobs [][]byte) net.Listener {
+	l, err := net.Listen("unix", path)
+	assert.Equal(t, nil, err, "Do not return a error")
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				for {
+					req, err := read_agent_message(conn)
+					if err != nil {
+						return
+					}
+					resp := []byte{ssh_agent_failure}
+					switch req[0] {
+					case ssh_agentc_request_identities:
+						resp = []byte{ssh_agent_identities_answer, 0, 0, 0, byte(len(blobs))}
+						for _, blob := range blobs {
+							resp = append_agent_string(append_agent_string(resp, blob), []byte("comment"))
+						}
+					case ssh_agentc_sign_request:
+						resp = []byte{14}
+					}
+					write_agent_message(conn, resp)
+				}
+			}(conn)
+		}
+	}()
+	return l
+}
+
+func TestResolveSshAgentKeys(t *testing.T) {
+	keys, err := resolve_ssh_agent_keys([]string{"SHA256:a", "SHA256:b"}, []string{})
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, []string{"SHA256:a", "SHA256:b"}, keys, "Keys of the config file not used")
+
+	keys, err = resolve_ssh_agent_keys([]string{"SHA256:a", "SHA256:b"}, []string{"SHA256:b"})
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, []string{"SHA256:b"}, keys, "Keys not restricted by the job")
+
+	_, err = resolve_ssh_agent_keys([]string{"SHA256:a"}, []string{"SHA256:c"})
+	assert.NotEqual(t, nil, err, "Key outside of the config file accepted")
+}
+
+func TestSshAgentProxy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+
+	key1, key2 := []byte("key1"), []byte("key2")
+	upstream := filepath.Join(dir, "agent.sock")
+	agent := fake_ssh_agent(t, upstream, [][]byte{key1, key2})
+	defer agent.Close()
+
+	p, err := start_ssh_agent_proxy(upstream, []string{ssh_key_fingerprint(key1)}, dir, os.Getuid(), os.Getgid())
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	conn, err := net.Dial("unix", ssh_agent_socket_path(dir))
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer conn.Close()
+
+	// the socket is only usable by the build user
+	info, err := os.Stat(filepath.Join(dir, ssh_agent_dir_name))
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm(), "Socket directory not private")
+	info, err = os.Stat(ssh_agent_socket_path(dir))
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Socket not private")
+
+	// only the allowed key is listed
+	write_agent_message(conn, []byte{ssh_agentc_request_identities})
+	resp, err := read_agent_message(conn)
+	assert.Equal(t, nil, err, "Do not return a error")
+	blob, _, _ := read_agent_string(resp[5:])
+	assert.Equal(t, []byte{ssh_agent_identities_answer, 0, 0, 0, 1}, resp[:5], "Keys not filtered")
+	assert.Equal(t, key1, blob, "Allowed key not listed")
+
+	// signing is refused for other keys
+	write_agent_message(conn, append_agent_string([]byte{ssh_agentc_sign_request}, key2))
+	resp, _ = read_agent_message(conn)
+	assert.Equal(t, []byte{ssh_agent_failure}, resp, "Sign request of a filtered key forwarded")
+
+	write_agent_message(conn, append_agent_string([]byte{ssh_agentc_sign_request}, key1))
+	resp, _ = read_agent_message(conn)
+	assert.Equal(t, []byte{14}, resp, "Sign request of an allowed key not forwarded")
+
+	// closing removes the socket of the build only
+	p.close()
+	_, err = os.Stat(filepath.Join(dir, ssh_agent_dir_name))
+	assert.Equal(t, true, os.IsNotExist(err), "Socket of the build not removed")
+	_, err = os.Stat(upstream)
+	assert.Equal(t, nil, err, "Agent socket of the caller removed")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-units"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"github.com/fsouza/go-dockerclient"
+	"sync"
+	"time"
+)
+
+// resource usage of a single build
+type resource_usage struct {
+	JobName         string  `json:"job_name"`
+	BuildID         int     `json:"build_id"`
+	ImageName       string  `json:"image_name"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	PeakMemoryBytes uint64  `json:"peak_memory_bytes"`
+	CPUTimeNanos    uint64  `json:"cpu_time_ns"`
+	BlockReadBytes  uint64  `json:"block_read_bytes"`
+	BlockWriteBytes uint64  `json:"block_write_bytes"`
+	NetworkRxBytes  uint64  `json:"network_rx_bytes"`
+	NetworkTxBytes  uint64  `json:"network_tx_bytes"`
+	Samples         int     `json:"samples"`
+}
+
+type stats_collector struct {
+	dw       *docker_wrapper.DockerWrapper
+	stats    chan *docker.Stats
+	done     chan bool
+	finished chan bool
+	started  time.Time
+	mutex    sync.Mutex
+	usage    resource_usage
+	stopped  sync.Once
+}
+
+// merge a stats sample into the usage, counters are cumulative so the last sample wins
+func (u *resource_usage) add_sample(s *docker.Stats) {
+	if s == nil {
+		return
+	}
+	u.Samples++
+
+	// memory
+	if s.MemoryStats.MaxUsage > u.PeakMemoryBytes {
+		u.PeakMemoryBytes = s.MemoryStats.MaxUsage
+	}
+	if s.MemoryStats.Usage > u.PeakMemoryBytes {
+		u.PeakMemoryBytes = s.MemoryStats.Usage
+	}
+
+	// cpu
+	if s.CPUStats.CPUUsage.TotalUsage > u.CPUTimeNanos {
+		u.CPUTimeNanos = s.CPUStats.CPUUsage.TotalUsage
+	}
+
+	// block io
+	var read, write uint64
+	for _, entry := range s.BlkioStats.IOServiceBytesRecursive {
+		switch entry.Op {
+		case "Read":
+			read += entry.Value
+		case "Write":
+			write += entry.Value
+		}
+	}
+	if read > u.BlockReadBytes {
+		u.BlockReadBytes = read
+	}
+	if write > u.BlockWriteBytes {
+		u.BlockWriteBytes = write
+	}
+
+	// network
+	rx, tx := s.Network.RxBytes, s.Network.TxBytes
+	if len(s.Networks) > 0 {
+		rx, tx = 0, 0
+		for _, network := range s.Networks {
+			rx += network.RxBytes
+			tx += network.TxBytes
+		}
+	}
+	if rx > u.NetworkRxBytes {
+		u.NetworkRxBytes = rx
+	}
+	if tx > u.NetworkTxBytes {
+		u.NetworkTxBytes = tx
+	}
+}
+
+// log a human readable summary of the usage
+func (u *resource_usage) report() {
+	log.Infof(
+		"Resource usage: peak memory=%s cpu time=%s block io read=%s write=%s network rx=%s tx=%s",
+		units.BytesSize(float64(u.PeakMemoryBytes)),
+		time.Duration(u.CPUTimeNanos),
+		units.BytesSize(float64(u.BlockReadBytes)),
+		units.BytesSize(float64(u.BlockWriteBytes)),
+		units.BytesSize(float64(u.NetworkRxBytes)),
+		units.BytesSize(float64(u.NetworkTxBytes)),
+	)
+}
+
+// start sampling the stats stream of the build container
+func collect_stats(dw *docker_wrapper.DockerWrapper) *stats_collector {
+	c := &stats_collector{
+		dw:       dw,
+		stats:    make(chan *docker.Stats),
+		done:     make(chan bool),
+		finished: make(chan bool),
+		started:  time.Now(),
+	}
+
+	go func() {
+		err := dw.Stats(c.stats, c.done)
+		select {
+		case <-c.done:
+			// stopping the stream closes the reader
+		default:
+			if err != nil {
+				log.Warnf("Can't read container stats: %s", err)
+			}
+		}
+	}()
+
+	go func() {
+		for s := range c.stats {
+			c.mutex.Lock()
+			c.usage.add_sample(s)
+			c.mutex.Unlock()
+		}
+		close(c.finished)
+	}()
+
+	return c
+}
+
+// stop sampling and return the collected usage
+func (c *stats_collector) stop() resource_usage {
+	c.stopped.Do(func() { close(c.done) })
+	select {
+	case <-c.finished:
+	case <-time.After(2 * time.Second):
+		log.Warn("Timeout while waiting for container stats")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	usage := c.usage
+	usage.DurationSeconds = time.Since(c.started).Seconds()
+	return usage
+}
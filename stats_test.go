@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResourceUsageAddSample(t *testing.T) {
+	var u resource_usage
+
+	s1 := &docker.Stats{}
+	s1.MemoryStats.Usage = 100
+	s1.MemoryStats.MaxUsage = 200
+	s1.CPUStats.CPUUsage.TotalUsage = 1000
+	s1.BlkioStats.IOServiceBytesRecursive = []docker.BlkioStatsEntry{
+		{Op: "Read", Value: 10},
+		{Op: "Write", Value: 20},
+		{Op: "Total", Value: 30},
+	}
+	s1.Networks = map[string]docker.NetworkStats{
+		"eth0": {RxBytes: 5, TxBytes: 6},
+		"eth1": {RxBytes: 1, TxBytes: 1},
+	}
+	u.add_sample(s1)
+
+	s2 := &docker.Stats{}
+	s2.MemoryStats.Usage = 150
+	s2.CPUStats.CPUUsage.TotalUsage = 3000
+	u.add_sample(s2)
+	u.add_sample(nil)
+
+	assert.Equal(t, 2, u.Samples, "Samples not counted")
+	assert.Equal(t, uint64(200), u.PeakMemoryBytes, "Peak memory not correct")
+	assert.Equal(t, uint64(3000), u.CPUTimeNanos, "CPU time not correct")
+	assert.Equal(t, uint64(10), u.BlockReadBytes, "Block read not correct")
+	assert.Equal(t, uint64(20), u.BlockWriteBytes, "Block write not correct")
+	assert.Equal(t, uint64(6), u.NetworkRxBytes, "Network rx not correct")
+	assert.Equal(t, uint64(7), u.NetworkTxBytes, "Network tx not correct")
+}
+
+func TestStatsCollectorStop(t *testing.T) {
+	c := &stats_collector{
+		done:     make(chan bool),
+		finished: make(chan bool),
+	}
+	c.usage.PeakMemoryBytes = 42
+	close(c.finished)
+
+	// the deferred stop after the reported one must not panic
+	usage := c.stop()
+	assert.Equal(t, uint64(42), usage.PeakMemoryBytes, "Usage not returned")
+	c.stop()
+}
+
+func TestWorkspaceFilePath(t *testing.T) {
+	config.workspace_path = "/jenkins/workspace/kunde1"
+
+	path, err := workspace_file_path("stats.json")
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "/jenkins/workspace/kunde1/stats.json", path, "Relative path not joined")
+
+	path, err = workspace_file_path("/jenkins/workspace/kunde1/out/stats.json")
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "/jenkins/workspace/kunde1/out/stats.json", path, "Absolute path not accepted")
+
+	_, err = workspace_file_path("../kunde2/stats.json")
+	assert.NotEqual(t, nil, err, "Path outside of workspace accepted")
+
+	_, err = workspace_file_path("/jenkins/workspace/kunde1-evil/stats.json")
+	assert.NotEqual(t, nil, err, "Path with common prefix accepted")
+}
+
+func TestWriteJsonFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "stats.json")
+	err = write_json_file(path, map[string]int{"a": 1})
+	assert.Equal(t, nil, err, "Do not return a error")
+	b, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "{\n  \"a\": 1\n}\n", string(b), "Content not correct")
+
+	// a planted symlink is not followed
+	target := filepath.Join(dir, "target")
+	ioutil.WriteFile(target, []byte("keep"), 0644)
+	link := filepath.Join(dir, "link.json")
+	os.Symlink(target, link)
+	err = write_json_file(link, map[string]int{"a": 1})
+	assert.NotEqual(t, nil, err, "Symlink followed")
+	b, _ = ioutil.ReadFile(target)
+	assert.Equal(t, "keep", string(b), "Symlink target overwritten")
+}
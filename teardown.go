@@ -0,0 +1,76 @@
+package main
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// exit code base of a build aborted by a signal
+const exit_code_signal = 128
+
+// resources of running builds, removed on return, fatal errors and aborts
+var teardowns = struct {
+	sync.Mutex
+	next int
+	fns  map[int]func()
+}{fns: map[int]func(){}}
+
+// register a teardown, it runs at most once
+func add_teardown(fn func()) int {
+	var once sync.Once
+	teardowns.Lock()
+	defer teardowns.Unlock()
+	teardowns.next++
+	teardowns.fns[teardowns.next] = func() { once.Do(fn) }
+	return teardowns.next
+}
+
+// run a registered teardown and forget it
+func run_teardown(id int) {
+	teardowns.Lock()
+	fn := teardowns.fns[id]
+	delete(teardowns.fns, id)
+	teardowns.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// forget a registered teardown without running it
+func drop_teardown(id int) {
+	teardowns.Lock()
+	delete(teardowns.fns, id)
+	teardowns.Unlock()
+}
+
+// run all pending teardowns, newest first
+func run_teardowns() {
+	teardowns.Lock()
+	ids := []int{}
+	for id := range teardowns.fns {
+		ids = append(ids, id)
+	}
+	teardowns.Unlock()
+	sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	for _, id := range ids {
+		run_teardown(id)
+	}
+}
+
+// tear down the builds when jenkins aborts the job
+func handle_signals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig := <-signals
+		log.Warnf("Received %s, tearing down the build", sig)
+		cleanup()
+		ret_val := exit_code_signal + int(sig.(syscall.Signal))
+		audit_write(ret_val)
+		os.Exit(ret_val)
+	}()
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRunTeardown(t *testing.T) {
+	calls := 0
+	id := add_teardown(func() { calls++ })
+	run_teardown(id)
+	run_teardown(id)
+	assert.Equal(t, 1, calls, "Teardown has to run once")
+
+	id = add_teardown(func() { calls++ })
+	drop_teardown(id)
+	run_teardowns()
+	assert.Equal(t, 1, calls, "Dropped teardown must not run")
+}
+
+func TestRunTeardowns(t *testing.T) {
+	order := []string{}
+	add_teardown(func() { order = append(order, "network") })
+	add_teardown(func() { order = append(order, "container") })
+	run_teardowns()
+	assert.Equal(t, []string{"container", "network"}, order, "Teardowns have to run newest first")
+
+	run_teardowns()
+	assert.Equal(t, 2, len(order), "Teardowns have to run once")
+}
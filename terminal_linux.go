@@ -0,0 +1,55 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+func terminal_get_state(fd uintptr) (*syscall.Termios, error) {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return &termios, nil
+}
+
+func terminal_set_state(fd uintptr, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCSETS, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// test if fd is a terminal
+func is_terminal(fd uintptr) bool {
+	_, err := terminal_get_state(fd)
+	return err == nil
+}
+
+// put the terminal into raw mode, returns a function to restore the old state
+func terminal_make_raw(fd uintptr) (func(), error) {
+	old, err := terminal_get_state(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := *old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+
+	err = terminal_set_state(fd, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		terminal_set_state(fd, old)
+	}, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"github.com/danryan/go-group/os/group"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"github.com/fsouza/go-dockerclient"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// file or directory injected into the container
+type archive_entry struct {
+	name    string
+	dir     bool
+	mode    int64
+	uid     int
+	gid     int
+	content []byte
+}
+
+// look up group and supplementary groups of the authenticated caller
+func resolve_jenkins_user() error {
+	c := config.caller
+	if c.uid == 0 || c.gid == 0 {
+		return fmt.Errorf("Invalid user '%s', builds must not run as root", c.username)
+	}
+	gid_str := strconv.Itoa(c.gid)
+	group_struct, err := group.LookupGroupId(gid_str)
+	if err != nil {
+		return err
+	}
+	user_struct, err := user.LookupId(strconv.Itoa(c.uid))
+	if err != nil {
+		return err
+	}
+	groups, err := lookup_supplementary_groups(user_struct, gid_str)
+	if err != nil {
+		return err
+	}
+
+	config.jenkins_uid = c.uid
+	config.jenkins_gid = c.gid
+	config.jenkins_group = group_struct.Name
+	config.jenkins_groups = filter_groups(config.allowed_groups, groups)
+	return nil
+}
+
+// test if a line of a passwd or group file clashes by name or id
+func id_entry_clashes(line string, name string, id int) bool {
+	fields := strings.Split(line, ":")
+	return len(fields) > 2 && (fields[0] == name || fields[2] == strconv.Itoa(id))
+}
+
+// names of entries of a passwd or group file that clash by name or id
+func clashing_entries(content []byte, name string, id int) []string {
+	names := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if id_entry_clashes(line, name, id) {
+			names = append(names, strings.Split(line, ":")[0])
+		}
+	}
+	return names
+}
+
+// replace entries of a passwd or group file that clash by name or id with the entry
+func merge_id_file(content []byte, name string, id int, entry string) []byte {
+	out := new(bytes.Buffer)
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" || id_entry_clashes(line, name, id) {
+			continue
+		}
+		out.WriteString(line)
+		out.WriteString("\n")
+	}
+	out.WriteString(entry)
+	out.WriteString("\n")
+	return out.Bytes()
+}
+
+// passwd file of the image with the jenkins user
+func merge_passwd(content []byte, username string, uid int, gid int, home string, shell string) []byte {
+	entry := fmt.Sprintf("%s:x:%d:%d::%s:%s", username, uid, gid, home, shell)
+	return merge_id_file(content, username, uid, entry)
+}
+
+// group file of the image with the primary and supplementary groups of the jenkins user
+func merge_group(content []byte, groupname string, gid int, username string, groups []host_group) []byte {
+	entry := fmt.Sprintf("%s:x:%d:", groupname, gid)
+	content = merge_id_file(content, groupname, gid, entry)
+	for _, g := range groups {
+		entry = fmt.Sprintf("%s:x:%d:%s", g.name, g.gid, username)
+		content = merge_id_file(content, g.name, g.gid, entry)
+	}
+	return content
+}
+
+// tar archive of the entries
+func build_archive(entries []archive_entry) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	writer := tar.NewWriter(buf)
+	now := time.Now()
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:    entry.name,
+			Mode:    entry.mode,
+			Uid:     entry.uid,
+			Gid:     entry.gid,
+			ModTime: now,
+		}
+		if entry.dir {
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+		} else {
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(entry.content))
+		}
+		err := writer.WriteHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		if !entry.dir {
+			_, err = writer.Write(entry.content)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf, writer.Close()
+}
+
+// read a file of the image, missing files are empty
+func download_image_file(dw *docker_wrapper.DockerWrapper, path string) ([]byte, error) {
+	content, err := dw.DownloadFile(path)
+	if err != nil {
+		if docker_err, ok := err.(*docker.Error); ok && docker_err.Status == 404 {
+			return []byte{}, nil
+		}
+		return nil, fmt.Errorf("Can't read '%s' of the image: %s", path, err)
+	}
+	return content, nil
+}
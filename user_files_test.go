@@ -0,0 +1,52 @@
+package main
+
+import (
+	"archive/tar"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMergePasswd(t *testing.T) {
+	passwd := []byte("root:x:0:0:root:/root:/bin/bash\nnode:x:1000:1000::/home/node:/bin/sh\njenkins:x:999:999::/var/jenkins:/bin/sh\n")
+	merged := merge_passwd(passwd, "jenkins", 1000, 1001, "/jenkins", "/bin/bash")
+	assert.Equal(t, "root:x:0:0:root:/root:/bin/bash\njenkins:x:1000:1001::/jenkins:/bin/bash\n", string(merged), "Clashing users not replaced")
+
+	merged = merge_passwd([]byte{}, "jenkins", 1000, 1001, "/jenkins", "/bin/sh")
+	assert.Equal(t, "jenkins:x:1000:1001::/jenkins:/bin/sh\n", string(merged), "Missing passwd not created")
+}
+
+func TestMergeGroup(t *testing.T) {
+	group_file := []byte("root:x:0:\nusers:x:100:\nnode:x:1001:\n")
+	merged := merge_group(group_file, "jenkins", 1001, "jenkins", nil)
+	assert.Equal(t, "root:x:0:\nusers:x:100:\njenkins:x:1001:\n", string(merged), "Clashing group not replaced")
+
+	merged = merge_group(group_file, "jenkins", 1001, "jenkins", []host_group{{name: "cache", gid: 100}})
+	assert.Equal(t, "root:x:0:\njenkins:x:1001:\ncache:x:100:jenkins\n", string(merged), "Supplementary group not added")
+}
+
+func TestBuildArchive(t *testing.T) {
+	buf, err := build_archive([]archive_entry{
+		{name: "jenkins", dir: true, mode: 0755, uid: 1000, gid: 1001},
+		{name: "etc/passwd", mode: 0644, content: []byte("root:x:0:0::/root:/bin/sh\n")},
+	})
+	assert.Equal(t, nil, err, "Do not return a error")
+
+	reader := tar.NewReader(buf)
+	header, err := reader.Next()
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "jenkins/", header.Name, "Directory name not correct")
+	assert.Equal(t, 1000, header.Uid, "Owner not correct")
+
+	header, err = reader.Next()
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "etc/passwd", header.Name, "File name not correct")
+	content, _ := ioutil.ReadAll(reader)
+	assert.Equal(t, "root:x:0:0::/root:/bin/sh\n", string(content), "File content not correct")
+}
+
+func TestClashingEntries(t *testing.T) {
+	passwd := []byte("root:x:0:0:root:/root:/bin/bash\nnode:x:1000:1000::/home/node:/bin/sh\njenkins:x:999:999::/var/jenkins:/bin/sh\n")
+	assert.Equal(t, []string{"node", "jenkins"}, clashing_entries(passwd, "jenkins", 1000), "Clashing users not found")
+	assert.Equal(t, []string{}, clashing_entries(passwd, "builder", 2000), "Unexpected clash found")
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/former03/jenkins_docker_wrapper/docker_wrapper"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// suffix of the pipeline directory with the wrapper scripts of the durable task plugin
+const workspace_tmp_suffix = "@tmp"
+
+// suffixes of pipeline helper directories, never the workspace of a build
+var workspace_helper_suffixes = []string{workspace_tmp_suffix, "@script", "@libs"}
+
+// directory containing the workspaces of all jobs
+func workspace_root() string {
+	return filepath.Join(config.jenkins_home, "workspace")
+}
+
+// test if path is below base, compared by path components
+func path_within(base string, path string) bool {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// resolve the symlinks of the existing part of a path, the rest is appended
+func resolve_existing(path string) (string, error) {
+	path = filepath.Clean(path)
+	rest := ""
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(path), rest)
+		path = parent
+	}
+}
+
+// check a workspace path without touching the filesystem
+func check_workspace_path(path string) (string, error) {
+	path = filepath.Clean(path)
+	root := workspace_root()
+	if !path_within(root, path) {
+		return "", fmt.Errorf("Invalid workspace '%s', expected to be within %s", path, root)
+	}
+	// concurrent pipeline builds use job@2, job@3, ...
+	if is_workspace_helper(path) {
+		return "", fmt.Errorf("Invalid workspace '%s', expected not to be a pipeline helper directory", path)
+	}
+	return path, nil
+}
+
+// resolve the symlinks of the workspace and check it is a directory of the jenkins user
+func resolve_workspace(path string) (string, os.FileInfo, error) {
+	if path == "" {
+		return "", nil, errors.New("No workspace set, WORKSPACE is missing in the environment")
+	}
+	resolved, info, err := resolve_workspace_dir(path)
+	audit_policy("workspace_resolve", path, err)
+	if err != nil {
+		return "", nil, err
+	}
+	return resolved, info, nil
+}
+
+// resolve a directory below the workspace root owned by the jenkins user, the returned
+// info identifies the directory checked
+func resolve_workspace_dir(path string) (string, os.FileInfo, error) {
+	var resolved string
+	var info os.FileInfo
+	err := as_caller(func() (err error) {
+		root, err := filepath.EvalSymlinks(workspace_root())
+		if err != nil {
+			return err
+		}
+		resolved, err = filepath.EvalSymlinks(path)
+		if err != nil {
+			return err
+		}
+		if !path_within(root, resolved) {
+			return fmt.Errorf("Invalid workspace '%s', resolves to %s outside of %s", path, resolved, root)
+		}
+		info, err = os.Stat(resolved)
+		return err
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if !info.IsDir() {
+		return "", nil, fmt.Errorf("Invalid workspace '%s', expected to be a directory", path)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != config.jenkins_uid {
+		return "", nil, fmt.Errorf("Invalid workspace '%s', expected to be owned by uid %d", path, config.jenkins_uid)
+	}
+	return resolved, info, nil
+}
+
+// bind mount of a resolved directory, checked in the started container
+type mount_check struct {
+	target string      // Path in the container
+	info   os.FileInfo // Directory checked before the container was created
+}
+
+// check a mount seen below root is the directory checked before, a path component
+// swapped for a symlink in between would mount another directory
+func check_mount(root string, check mount_check) error {
+	info, err := os.Stat(filepath.Join(root, check.target))
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(info, check.info) {
+		return fmt.Errorf("Mount %s of the container is not the directory checked before", check.target)
+	}
+	return nil
+}
+
+// check the mounts in the mount namespace of the started container
+func verify_mounts(dw *docker_wrapper.DockerWrapper, checks []mount_check) error {
+	container, err := dw.Inspect()
+	if err != nil {
+		return err
+	}
+	root := fmt.Sprintf("/proc/%d/root", container.State.Pid)
+	for _, check := range checks {
+		err = check_mount(root, check)
+		audit_policy("workspace_mount", check.target, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pipeline helper directory next to the workspace, like job@2@tmp
+func workspace_sibling(workspace string, suffix string) string {
+	return workspace + suffix
+}
+
+// test if a path is a pipeline helper directory instead of a workspace
+func is_workspace_helper(path string) bool {
+	for _, suffix := range workspace_helper_suffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve the @tmp sibling of the workspace holding the scripts of pipeline sh steps
+//
+// freestyle jobs have none, a missing directory is no error
+func resolve_workspace_tmp(workspace string) (string, os.FileInfo, error) {
+	path := workspace_sibling(workspace, workspace_tmp_suffix)
+	exists := true
+	err := as_caller(func() error {
+		_, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			exists = false
+			return nil
+		}
+		return err
+	})
+	if err != nil || !exists {
+		return "", nil, err
+	}
+	resolved, info, err := resolve_workspace_dir(path)
+	audit_policy("workspace_tmp", path, err)
+	if err != nil {
+		return "", nil, err
+	}
+	return resolved, info, nil
+}
+
+// test if a path is within the mounted @tmp directory of the workspace
+func in_workspace_tmp(path string) bool {
+	if config.workspace_tmp == "" {
+		return false
+	}
+	return path_within(workspace_sibling(config.workspace_path, workspace_tmp_suffix), filepath.Clean(path))
+}
+
+// resolve a path relative to the workspace and ensure it stays within
+func workspace_file_path(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(config.workspace_path, path)
+	}
+	path = filepath.Clean(path)
+
+	// symlinks are followed with the credentials of the caller
+	var workspace, resolved string
+	err := as_caller(func() (err error) {
+		workspace, err = resolve_existing(config.workspace_path)
+		if err != nil {
+			return err
+		}
+		resolved, err = resolve_existing(path)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if !path_within(workspace, resolved) {
+		return "", fmt.Errorf("Invalid path '%s', expected to be within %s", path, config.workspace_path)
+	}
+	return resolved, nil
+}
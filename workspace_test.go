@@ -0,0 +1,142 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathWithin(t *testing.T) {
+	assert.Equal(t, true, path_within("/jenkins/workspace", "/jenkins/workspace/kunde1"), "Sub directory not accepted")
+	assert.Equal(t, false, path_within("/jenkins/workspace", "/jenkins/workspace"), "Base itself accepted")
+	assert.Equal(t, false, path_within("/jenkins/workspace", "/jenkins/workspace-evil"), "Common prefix accepted")
+	assert.Equal(t, false, path_within("/jenkins/workspace", "/jenkins"), "Parent accepted")
+	assert.Equal(t, true, path_within("/jenkins/workspace", "/jenkins/workspace/..data"), "Dot prefixed name not accepted")
+}
+
+func TestCheckWorkspacePath(t *testing.T) {
+	config.jenkins_home = "/jenkins"
+
+	path, err := check_workspace_path("/jenkins/workspace/kunde1/../kunde2")
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "/jenkins/workspace/kunde2", path, "Path not cleaned")
+
+	_, err = check_workspace_path("/jenkins/workspace-evil/kunde1")
+	assert.NotEqual(t, nil, err, "Path with common prefix accepted")
+
+	_, err = check_workspace_path("/jenkins/workspace/")
+	assert.NotEqual(t, nil, err, "Workspace root accepted")
+}
+
+func TestResolveWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+
+	config.jenkins_home = dir
+	config.jenkins_uid = os.Getuid()
+	os.MkdirAll(filepath.Join(dir, "workspace", "kunde1"), 0755)
+	os.Mkdir(filepath.Join(dir, "outside"), 0755)
+	os.Symlink(filepath.Join(dir, "workspace", "kunde1"), filepath.Join(dir, "workspace", "link"))
+	os.Symlink(filepath.Join(dir, "outside"), filepath.Join(dir, "workspace", "escape"))
+
+	path, _, err := resolve_workspace(filepath.Join(dir, "workspace", "link"))
+	assert.Equal(t, nil, err, "Do not return a error")
+	resolved, _ := filepath.EvalSymlinks(filepath.Join(dir, "workspace", "kunde1"))
+	assert.Equal(t, resolved, path, "Symlink not resolved")
+
+	_, _, err = resolve_workspace(filepath.Join(dir, "workspace", "escape"))
+	assert.NotEqual(t, nil, err, "Symlink out of the workspace accepted")
+
+	_, _, err = resolve_workspace(filepath.Join(dir, "workspace", "missing"))
+	assert.NotEqual(t, nil, err, "Missing workspace accepted")
+
+	config.jenkins_uid = os.Getuid() + 1
+	_, _, err = resolve_workspace(filepath.Join(dir, "workspace", "kunde1"))
+	assert.NotEqual(t, nil, err, "Workspace of another user accepted")
+}
+
+func TestResolveExisting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "real"), 0755)
+	os.Symlink(filepath.Join(dir, "real"), filepath.Join(dir, "link"))
+	resolved_dir, _ := filepath.EvalSymlinks(dir)
+
+	path, err := resolve_existing(filepath.Join(dir, "link", "new", "stats.json"))
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, filepath.Join(resolved_dir, "real", "new", "stats.json"), path, "Existing part not resolved")
+}
+
+func TestPipelineWorkspace(t *testing.T) {
+	config.jenkins_home = "/jenkins"
+
+	_, err := check_workspace_path("/jenkins/workspace/job@2")
+	assert.Equal(t, nil, err, "Concurrent pipeline workspace not accepted")
+
+	_, err = check_workspace_path("/jenkins/workspace/job@tmp")
+	assert.NotEqual(t, nil, err, "Helper directory accepted as workspace")
+
+	_, err = check_workspace_path("/jenkins/workspace/job@script")
+	assert.NotEqual(t, nil, err, "Helper directory accepted as workspace")
+
+	assert.Equal(t, "/jenkins/workspace/job@2@tmp", workspace_sibling("/jenkins/workspace/job@2", workspace_tmp_suffix), "Sibling not correct")
+}
+
+func TestResolveWorkspaceTmp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+
+	config.jenkins_home = dir
+	config.jenkins_uid = os.Getuid()
+	workspace := filepath.Join(dir, "workspace", "job@2")
+	os.MkdirAll(workspace, 0755)
+
+	// freestyle jobs have no @tmp directory
+	path, _, err := resolve_workspace_tmp(workspace)
+	assert.Equal(t, nil, err, "Do not return a error")
+	assert.Equal(t, "", path, "Missing directory returned")
+
+	os.Mkdir(workspace+"@tmp", 0755)
+	path, _, err = resolve_workspace_tmp(workspace)
+	assert.Equal(t, nil, err, "Do not return a error")
+	resolved, _ := filepath.EvalSymlinks(workspace + "@tmp")
+	assert.Equal(t, resolved, path, "Tmp directory not resolved")
+
+	// scripts of sh steps stay in the mounted directory
+	config.workspace_path = workspace
+	config.workspace_tmp = path
+	assert.Equal(t, true, in_workspace_tmp(workspace+"@tmp/durable-1234/script.sh"), "Script in @tmp not detected")
+	assert.Equal(t, false, in_workspace_tmp("/tmp/hudson1234.sh"), "Script outside of @tmp detected")
+	config.workspace_tmp = ""
+
+	// a symlink must not escape the workspace root
+	os.Remove(workspace + "@tmp")
+	os.Symlink(dir, workspace+"@tmp")
+	_, _, err = resolve_workspace_tmp(workspace)
+	assert.NotEqual(t, nil, err, "Symlink out of the workspace accepted")
+}
+
+func TestCheckMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jenkins_docker_wrapper_test")
+	assert.Equal(t, nil, err, "Do not return a error")
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, "workspace", "kunde1"), 0755)
+	os.Mkdir(filepath.Join(dir, "outside"), 0755)
+	info, err := os.Stat(filepath.Join(dir, "workspace", "kunde1"))
+	assert.Equal(t, nil, err, "Do not return a error")
+	check := mount_check{target: "/workspace/kunde1", info: info}
+
+	assert.Equal(t, nil, check_mount(dir, check), "Do not return a error")
+
+	// a directory swapped for a symlink after the check
+	os.Rename(filepath.Join(dir, "workspace", "kunde1"), filepath.Join(dir, "workspace", "old"))
+	os.Symlink(filepath.Join(dir, "outside"), filepath.Join(dir, "workspace", "kunde1"))
+	assert.NotEqual(t, nil, check_mount(dir, check), "Swapped directory accepted")
+}